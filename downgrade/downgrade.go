@@ -0,0 +1,16 @@
+package downgrade
+
+import "context"
+
+type downgradeKey struct{}
+
+// WithDowngrade 将请求标记为降级，下游业务可以据此返回兜底数据或跳过非核心逻辑
+func WithDowngrade(ctx context.Context) context.Context {
+	return context.WithValue(ctx, downgradeKey{}, true)
+}
+
+// IsDowngraded 判断请求是否被标记为降级
+func IsDowngraded(ctx context.Context) bool {
+	val, _ := ctx.Value(downgradeKey{}).(bool)
+	return val
+}
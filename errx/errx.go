@@ -0,0 +1,177 @@
+package errx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error 业务错误
+//
+// Code 决定 gRPC 状态码与 HTTP 状态码，Reason 为业务上的错误原因，如 USER_NOT_FOUND，
+// 二者相同即视为同一种错误，可以通过 errors.Is 判断
+type Error struct {
+	Code     codes.Code
+	Reason   string
+	Message  string
+	Metadata map[string]string
+	cause    error
+}
+
+func New(code codes.Code, reason, message string) *Error {
+	return &Error{
+		Code:    code,
+		Reason:  reason,
+		Message: message,
+	}
+}
+
+func Errorf(code codes.Code, reason, format string, args ...any) *Error {
+	return New(code, reason, fmt.Sprintf(format, args...))
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("code = %s reason = %s message = %s", e.Code, e.Reason, e.Message)
+	if len(e.Metadata) > 0 {
+		msg += fmt.Sprintf(" metadata = %v", e.Metadata)
+	}
+	if e.cause != nil {
+		msg += fmt.Sprintf(" cause = %v", e.cause)
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is Code 与 Reason 相同即视为同一种错误
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code && t.Reason == e.Reason
+}
+
+// WithCause 返回包装了 cause 的副本，原有的错误不变，便于将预定义的错误作为模板
+func (e *Error) WithCause(cause error) *Error {
+	res := e.clone()
+	res.cause = cause
+	return res
+}
+
+// WithMetadata 返回合并了 md 的副本，原有的错误不变
+func (e *Error) WithMetadata(md map[string]string) *Error {
+	res := e.clone()
+	res.Metadata = make(map[string]string, len(e.Metadata)+len(md))
+	for k, v := range e.Metadata {
+		res.Metadata[k] = v
+	}
+	for k, v := range md {
+		res.Metadata[k] = v
+	}
+	return res
+}
+
+func (e *Error) clone() *Error {
+	res := *e
+	return &res
+}
+
+// GRPCStatus 使 status.FromError 能够识别 Error，Reason 与 Metadata 以 errdetails.ErrorInfo 的形式放在 details 中
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(e.Code, e.Message)
+	if e.Reason == "" && len(e.Metadata) == 0 {
+		return st
+	}
+	ds, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   e.Reason,
+		Metadata: e.Metadata,
+	})
+	if err != nil {
+		return st
+	}
+	return ds
+}
+
+// HTTPStatus Code 对应的 HTTP 状态码
+func (e *Error) HTTPStatus() int {
+	return HTTPStatus(e.Code)
+}
+
+// FromError 将任意错误转换为 Error，err 为 nil 时返回 nil
+//
+// 错误链上有 Error 时直接返回；gRPC 的错误会从 details 中还原 Reason 与 Metadata；
+// context 的错误转换为 Canceled 或 DeadlineExceeded；其他错误视为 Unknown
+func FromError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		st = status.FromContextError(err)
+		return &Error{Code: st.Code(), Message: st.Message(), cause: err}
+	}
+	e = &Error{Code: st.Code(), Message: st.Message()}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			e.Reason = info.GetReason()
+			e.Metadata = info.GetMetadata()
+			break
+		}
+	}
+	return e
+}
+
+// Code err 为 nil 时返回 OK
+func Code(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+	return FromError(err).Code
+}
+
+func Reason(err error) string {
+	if err == nil {
+		return ""
+	}
+	return FromError(err).Reason
+}
+
+// HTTPStatus gRPC 状态码对应的 HTTP 状态码，与 grpc-gateway 的映射一致
+func HTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		// 客户端主动关闭连接，与 nginx 一致
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		// Unknown、Internal、DataLoss
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,111 @@
+package errx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+var ErrUserNotFound = New(codes.NotFound, "USER_NOT_FOUND", "用户不存在")
+
+func TestError(t *testing.T) {
+	cause := errors.New("record not found")
+	err := ErrUserNotFound.WithCause(cause).WithMetadata(map[string]string{"id": "1"})
+	// 不影响预定义的错误
+	assert.Nil(t, ErrUserNotFound.Metadata)
+	assert.Nil(t, ErrUserNotFound.Unwrap())
+
+	wrapped := fmt.Errorf("查询用户: %w", err)
+	assert.ErrorIs(t, wrapped, ErrUserNotFound)
+	assert.ErrorIs(t, wrapped, cause)
+	assert.NotErrorIs(t, wrapped, New(codes.NotFound, "ORDER_NOT_FOUND", ""))
+	assert.Equal(t, codes.NotFound, Code(wrapped))
+	assert.Equal(t, "USER_NOT_FOUND", Reason(wrapped))
+	assert.Equal(t, http.StatusNotFound, err.HTTPStatus())
+	assert.Equal(t, "code = NotFound reason = USER_NOT_FOUND message = 用户不存在 metadata = map[id:1] cause = record not found", err.Error())
+
+	// status.FromError 能够识别 Error，被包装时 message 为完整的错误信息
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.NotFound, st.Code())
+	assert.Equal(t, "用户不存在", st.Message())
+	assert.Equal(t, codes.NotFound, status.Code(wrapped))
+}
+
+func TestFromError(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want *Error
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name: "gRPC 错误",
+			err:  status.Error(codes.Unavailable, "连接失败"),
+			want: &Error{Code: codes.Unavailable, Message: "连接失败"},
+		},
+		{
+			name: "context 超时",
+			err:  context.DeadlineExceeded,
+			want: &Error{Code: codes.DeadlineExceeded, Message: context.DeadlineExceeded.Error(), cause: context.DeadlineExceeded},
+		},
+		{
+			name: "其他错误",
+			err:  errors.New("boom"),
+			want: &Error{Code: codes.Unknown, Message: "boom", cause: errors.New("boom")},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, FromError(tc.err))
+		})
+	}
+	assert.Equal(t, codes.OK, Code(nil))
+	assert.Equal(t, http.StatusTooManyRequests, HTTPStatus(codes.ResourceExhausted))
+	assert.Equal(t, 499, HTTPStatus(codes.Canceled))
+	assert.Equal(t, http.StatusInternalServerError, HTTPStatus(codes.DataLoss))
+}
+
+// TestError_GRPC 服务端返回的 Error 经过 gRPC 传输后在客户端还原
+func TestError_GRPC(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return nil, ErrUserNotFound.WithMetadata(map[string]string{"id": "1"}).WithCause(errors.New("不会传输"))
+	}))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(l)
+	}()
+	defer server.Stop()
+	cc, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{})
+	e := FromError(err)
+	assert.Equal(t, &Error{
+		Code:     codes.NotFound,
+		Reason:   "USER_NOT_FOUND",
+		Message:  "用户不存在",
+		Metadata: map[string]string{"id": "1"},
+	}, e)
+	assert.ErrorIs(t, e, ErrUserNotFound)
+}
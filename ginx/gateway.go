@@ -0,0 +1,383 @@
+package ginx
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/to404hanga/pkg404/errx"
+	"github.com/to404hanga/pkg404/grpcx/interceptor"
+	"github.com/to404hanga/pkg404/grpcx/interceptor/auth"
+	"github.com/to404hanga/pkg404/logger"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// MetadataHeaderPrefix 以该前缀开头的请求头去掉前缀后作为 metadata 转发，与 grpc-gateway 一致，调用方身份与认证相关的 key 除外
+const MetadataHeaderPrefix = "Grpc-Metadata-"
+
+// DefaultMaxBodySize 请求体的默认上限，与 gRPC 服务端默认的消息上限一致
+const DefaultMaxBodySize = 4 << 20
+
+// reservedMetadata 调用方身份与认证相关的 metadata，只能由网关自身或拦截器设置，不能通过 MetadataHeaderPrefix 伪造
+var reservedMetadata = []string{
+	interceptor.AppKey,
+	interceptor.ClientIPKey,
+	interceptor.RequestIDKey,
+	auth.TokenKey,
+}
+
+// Gateway 将 gRPC 的一元方法挂载为 HTTP 接口，请求与响应使用 protojson 转换，响应为 Result，
+// 错误按 ErrorResult 转换为对应的 HTTP 状态码
+//
+// cc 可以是连接远程服务的 *grpc.ClientConn，也可以是进程内的 grpcx.InProcessConn
+type Gateway struct {
+	cc          grpc.ClientConnInterface
+	headers     []string
+	reserved    map[string]bool
+	maxBodySize int64
+	marshal     protojson.MarshalOptions
+	unmarshal   protojson.UnmarshalOptions
+}
+
+type GatewayOption func(g *Gateway)
+
+// WithForwardHeaders 需要作为 metadata 转发的请求头，默认只转发 X-Request-Id
+func WithForwardHeaders(headers ...string) GatewayOption {
+	return func(g *Gateway) {
+		g.headers = headers
+	}
+}
+
+// WithReservedMetadata 除 app、client-ip、x-request-id 与 x-service-token 外，
+// 其他不允许通过 MetadataHeaderPrefix 请求头转发的 metadata
+func WithReservedMetadata(keys ...string) GatewayOption {
+	return func(g *Gateway) {
+		for _, key := range keys {
+			g.reserved[strings.ToLower(key)] = true
+		}
+	}
+}
+
+// WithMaxBodySize 请求体的上限，超过时返回 InvalidArgument，默认为 DefaultMaxBodySize
+func WithMaxBodySize(size int64) GatewayOption {
+	return func(g *Gateway) {
+		if size > 0 {
+			g.maxBodySize = size
+		}
+	}
+}
+
+// WithMarshalOptions 序列化响应的参数，默认使用 protojson 的默认参数，即 lowerCamelCase 的字段名
+func WithMarshalOptions(opts protojson.MarshalOptions) GatewayOption {
+	return func(g *Gateway) {
+		g.marshal = opts
+	}
+}
+
+// WithUnmarshalOptions 反序列化请求体的参数，默认不允许未知字段
+func WithUnmarshalOptions(opts protojson.UnmarshalOptions) GatewayOption {
+	return func(g *Gateway) {
+		g.unmarshal = opts
+	}
+}
+
+func NewGateway(cc grpc.ClientConnInterface, opts ...GatewayOption) *Gateway {
+	g := &Gateway{
+		cc:          cc,
+		headers:     []string{"X-Request-Id"},
+		reserved:    make(map[string]bool, len(reservedMetadata)),
+		maxBodySize: DefaultMaxBodySize,
+	}
+	for _, key := range reservedMetadata {
+		g.reserved[key] = true
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Handle 将 fullMethod（如 /user.UserService/GetUser）挂载到 httpMethod 与 relativePath 上，
+// 方法的描述从 protoregistry.GlobalFiles 中查找
+//
+// relativePath 中的路径参数按名称写入请求的同名字段，可以使用 . 指定嵌套字段，如 /users/:user.id。
+// 与 ctx.Bind 一致，GET 从 query 中解析其他字段，其他方法从请求体中解析
+func (g *Gateway) Handle(r Router, httpMethod, relativePath, fullMethod string) error {
+	name := protoreflect.FullName(strings.ReplaceAll(strings.TrimPrefix(fullMethod, "/"), "/", "."))
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(name)
+	if err != nil {
+		return fmt.Errorf("ginx: 查找方法 %s 失败: %w", fullMethod, err)
+	}
+	md, ok := desc.(protoreflect.MethodDescriptor)
+	if !ok {
+		return fmt.Errorf("ginx: %s 不是方法", fullMethod)
+	}
+	body := "*"
+	if bindsQuery(strings.ToUpper(httpMethod)) {
+		body = ""
+	}
+	return g.handle(r, strings.ToUpper(httpMethod), relativePath, md, body)
+}
+
+// RegisterService 按 google.api.http 注解挂载服务的所有一元方法，没有注解的方法会被忽略
+//
+// 路径模板只支持 {field} 形式的变量，不支持 {field=pattern}、通配符与 :verb 后缀
+func (g *Gateway) RegisterService(r Router, sd protoreflect.ServiceDescriptor) error {
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		if md.IsStreamingClient() || md.IsStreamingServer() {
+			continue
+		}
+		rule, ok := proto.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule)
+		if !ok || rule == nil {
+			continue
+		}
+		for _, rule := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+			method, tpl, ok := httpPattern(rule)
+			if !ok {
+				return fmt.Errorf("ginx: 方法 %s 的注解缺少 HTTP 方法与路径", md.FullName())
+			}
+			relativePath, err := convertTemplate(tpl)
+			if err != nil {
+				return fmt.Errorf("ginx: 方法 %s: %w", md.FullName(), err)
+			}
+			if err = g.handle(r, method, relativePath, md, rule.GetBody()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handle body 为 * 时请求体对应整个请求，为字段名时对应该字段，为空时不读取请求体，其余字段从 query 中解析
+func (g *Gateway) handle(r Router, httpMethod, relativePath string, md protoreflect.MethodDescriptor, body string) error {
+	if body != "" && body != "*" {
+		fd := md.Input().Fields().ByName(protoreflect.Name(body))
+		if fd == nil || fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return fmt.Errorf("ginx: 方法 %s 的 body %s 不是消息类型的字段", md.FullName(), body)
+		}
+	}
+	fullMethod := fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+	input, output := messageType(md.Input()), messageType(md.Output())
+	r.Handle(httpMethod, relativePath, func(ctx *gin.Context) {
+		req := input.New().Interface()
+		if err := g.decode(ctx, req, body); err != nil {
+			L.Error("输入错误", logger.Error(err))
+			render(ctx, Result{}, errx.New(codes.InvalidArgument, "", err.Error()))
+			return
+		}
+		resp := output.New().Interface()
+		if err := g.cc.Invoke(g.outgoing(ctx), fullMethod, req, resp); err != nil {
+			L.Error("调用 gRPC 方法失败", logger.String("method", fullMethod), logger.Error(err))
+			render(ctx, Result{}, errx.FromError(err))
+			return
+		}
+		data, err := g.marshal.Marshal(resp)
+		if err != nil {
+			L.Error("序列化响应失败", logger.String("method", fullMethod), logger.Error(err))
+			render(ctx, Result{}, errx.New(codes.Internal, "", err.Error()))
+			return
+		}
+		render(ctx, Result{Data: json.RawMessage(data)}, nil)
+	})
+	return nil
+}
+
+// messageType 优先使用生成代码注册的类型，否则使用 dynamicpb
+func messageType(desc protoreflect.MessageDescriptor) protoreflect.MessageType {
+	if mt, err := protoregistry.GlobalTypes.FindMessageByName(desc.FullName()); err == nil {
+		return mt
+	}
+	return dynamicpb.NewMessageType(desc)
+}
+
+func (g *Gateway) decode(ctx *gin.Context, req proto.Message, body string) error {
+	if body != "" {
+		data, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, g.maxBodySize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return fmt.Errorf("ginx: 请求体超过 %d 字节", tooLarge.Limit)
+			}
+			return err
+		}
+		if len(data) > 0 {
+			target := req
+			if body != "*" {
+				msg := req.ProtoReflect()
+				target = msg.Mutable(msg.Descriptor().Fields().ByName(protoreflect.Name(body))).Message().Interface()
+			}
+			if err = g.unmarshal.Unmarshal(data, target); err != nil {
+				return err
+			}
+		}
+	}
+	if body != "*" {
+		for key, vals := range ctx.Request.URL.Query() {
+			for _, val := range vals {
+				if err := setField(req.ProtoReflect(), key, val); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	// 路径参数优先于请求体与 query
+	for _, p := range ctx.Params {
+		if err := setField(req.ProtoReflect(), p.Key, p.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Gateway) outgoing(ctx *gin.Context) context.Context {
+	md := metadata.MD{}
+	for _, h := range g.headers {
+		if vals := ctx.Request.Header.Values(h); len(vals) > 0 {
+			md.Append(h, vals...)
+		}
+	}
+	for key, vals := range ctx.Request.Header {
+		if !strings.HasPrefix(key, MetadataHeaderPrefix) {
+			continue
+		}
+		key = strings.ToLower(strings.TrimPrefix(key, MetadataHeaderPrefix))
+		if g.reserved[key] {
+			continue
+		}
+		md.Append(key, vals...)
+	}
+	return metadata.NewOutgoingContext(ctx.Request.Context(), md)
+}
+
+// setField 将字符串形式的 val 写入 path 指定的字段，path 可以使用 . 指定嵌套字段，字段名为 proto 名或 JSON 名
+func setField(msg protoreflect.Message, path, val string) error {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fields := msg.Descriptor().Fields()
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = fields.ByJSONName(name)
+		}
+		if fd == nil {
+			return fmt.Errorf("ginx: %s 没有字段 %s", msg.Descriptor().FullName(), path)
+		}
+		if i < len(names)-1 {
+			if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+				return fmt.Errorf("ginx: 字段 %s 不是消息类型", path)
+			}
+			msg = msg.Mutable(fd).Message()
+			continue
+		}
+		if fd.IsMap() || fd.Message() != nil {
+			return fmt.Errorf("ginx: 字段 %s 不是标量类型", path)
+		}
+		v, err := scalar(fd, val)
+		if err != nil {
+			return fmt.Errorf("ginx: 字段 %s: %w", path, err)
+		}
+		if fd.IsList() {
+			msg.Mutable(fd).List().Append(v)
+		} else {
+			msg.Set(fd, v)
+		}
+	}
+	return nil
+}
+
+func scalar(fd protoreflect.FieldDescriptor, val string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(val), nil
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(val)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(val, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(val, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(val, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(val, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(val, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(val, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(val)
+		return protoreflect.ValueOfBytes(v), err
+	case protoreflect.EnumKind:
+		// 支持枚举名与枚举值
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(val)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		v, err := strconv.ParseInt(val, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), err
+	default:
+		return protoreflect.Value{}, fmt.Errorf("不支持的类型 %s", fd.Kind())
+	}
+}
+
+func httpPattern(rule *annotations.HttpRule) (string, string, bool) {
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return http.MethodGet, p.Get, true
+	case *annotations.HttpRule_Post:
+		return http.MethodPost, p.Post, true
+	case *annotations.HttpRule_Put:
+		return http.MethodPut, p.Put, true
+	case *annotations.HttpRule_Delete:
+		return http.MethodDelete, p.Delete, true
+	case *annotations.HttpRule_Patch:
+		return http.MethodPatch, p.Patch, true
+	case *annotations.HttpRule_Custom:
+		return strings.ToUpper(p.Custom.GetKind()), p.Custom.GetPath(), p.Custom.GetKind() != ""
+	default:
+		return "", "", false
+	}
+}
+
+// convertTemplate 将 /v1/users/{user.id} 形式的路径模板转换为 gin 的 /v1/users/:user.id
+func convertTemplate(tpl string) (string, error) {
+	if !strings.HasPrefix(tpl, "/") {
+		return "", fmt.Errorf("路径模板 %s 需要以 / 开头", tpl)
+	}
+	segments := strings.Split(tpl[1:], "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			name := seg[1 : len(seg)-1]
+			if name == "" || strings.ContainsAny(name, "=*{}:") {
+				return "", fmt.Errorf("不支持路径模板 %s", tpl)
+			}
+			segments[i] = ":" + name
+			continue
+		}
+		if strings.ContainsAny(seg, "{}*:") {
+			return "", fmt.Errorf("不支持路径模板 %s", tpl)
+		}
+	}
+	return "/" + strings.Join(segments, "/"), nil
+}
@@ -0,0 +1,270 @@
+package ginx
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/errx"
+	"github.com/to404hanga/pkg404/grpcx"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func serve(engine *gin.Engine, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestGateway_Handle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hs := health.NewServer()
+	hs.SetServingStatus("user", healthpb.HealthCheckResponse_SERVING)
+
+	// 远程连接
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, hs)
+	go func() {
+		_ = server.Serve(l)
+	}()
+	defer server.Stop()
+	cc, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	// 进程内连接
+	conn := grpcx.NewInProcessConn()
+	healthpb.RegisterHealthServer(conn, hs)
+
+	for name, c := range map[string]grpc.ClientConnInterface{"remote": cc, "in-process": conn} {
+		t.Run(name, func(t *testing.T) {
+			engine := gin.New()
+			g := NewGateway(c)
+			require.NoError(t, g.Handle(engine, http.MethodGet, "/health/:service", healthpb.Health_Check_FullMethodName))
+			require.NoError(t, g.Handle(engine.Group("/v1"), http.MethodPost, "/health", healthpb.Health_Check_FullMethodName))
+
+			recorder := serve(engine, http.MethodGet, "/health/user", "")
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.JSONEq(t, `{"code":0,"msg":"","data":{"status":"SERVING"}}`, recorder.Body.String())
+
+			recorder = serve(engine, http.MethodPost, "/v1/health", `{"service":"user"}`)
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.JSONEq(t, `{"code":0,"msg":"","data":{"status":"SERVING"}}`, recorder.Body.String())
+
+			// gRPC 的错误码转换为 HTTP 状态码
+			recorder = serve(engine, http.MethodGet, "/health/order", "")
+			assert.Equal(t, http.StatusNotFound, recorder.Code)
+			assert.JSONEq(t, `{"code":100005,"msg":"unknown service","data":{"status":"NOT_FOUND","reason":""}}`, recorder.Body.String())
+
+			// 请求体格式错误
+			recorder = serve(engine, http.MethodPost, "/v1/health", `{"unknown":1}`)
+			assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		})
+	}
+
+	assert.Error(t, NewGateway(conn).Handle(gin.New(), http.MethodGet, "/x", "/user.UserService/GetUser"))
+}
+
+// echoFile 带有 google.api.http 注解的服务
+//
+//	service Echo {
+//	  rpc Get(EchoRequest) returns (EchoReply) { option (google.api.http) = { get: "/v1/echo/{msg}" }; }
+//	  rpc Post(EchoRequest) returns (EchoReply) {
+//	    option (google.api.http) = { post: "/v1/echo" body: "*" additional_bindings { put: "/v1/echo/{msg}" body: "inner" } };
+//	  }
+//	}
+func echoFile(t *testing.T) protoreflect.FileDescriptor {
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(num),
+			Type:     typ.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			JsonName: proto.String(name),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	method := func(name string, rule *annotations.HttpRule) *descriptorpb.MethodDescriptorProto {
+		opts := &descriptorpb.MethodOptions{}
+		proto.SetExtension(opts, annotations.E_Http, rule)
+		return &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".echo.EchoRequest"),
+			OutputType: proto.String(".echo.EchoReply"),
+			Options:    opts,
+		}
+	}
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("echo.proto"),
+		Package: proto.String("echo"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Inner"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				},
+			},
+			{
+				Name: proto.String("EchoRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("msg", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+					field("inner", 3, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".echo.Inner"),
+				},
+			},
+			{
+				Name: proto.String("EchoReply"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("msg", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+					field("name", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("tenant", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Echo"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("Get", &annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: "/v1/echo/{msg}"}}),
+				method("Post", &annotations.HttpRule{
+					Pattern: &annotations.HttpRule_Post{Post: "/v1/echo"},
+					Body:    "*",
+					AdditionalBindings: []*annotations.HttpRule{{
+						Pattern: &annotations.HttpRule_Put{Put: "/v1/echo/{msg}"},
+						Body:    "inner",
+					}},
+				}),
+			},
+		}},
+	}
+	fd, err := protodesc.NewFile(fdp, nil)
+	require.NoError(t, err)
+	return fd
+}
+
+func TestGateway_RegisterService(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fd := echoFile(t)
+	sd := fd.Services().ByName("Echo")
+	reqDesc, replyDesc := fd.Messages().ByName("EchoRequest"), fd.Messages().ByName("EchoReply")
+
+	// 使用 dynamicpb 实现服务，将请求原样返回，count 小于 0 时返回业务错误
+	var incoming metadata.MD
+	handler := func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		in := dynamicpb.NewMessage(reqDesc)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		count := in.Get(reqDesc.Fields().ByName("count")).Int()
+		if count < 0 {
+			return nil, errx.New(codes.InvalidArgument, "INVALID_COUNT", "count 不能小于 0")
+		}
+		out := dynamicpb.NewMessage(replyDesc)
+		out.Set(replyDesc.Fields().ByName("msg"), in.Get(reqDesc.Fields().ByName("msg")))
+		out.Set(replyDesc.Fields().ByName("count"), protoreflect.ValueOfInt32(int32(count)))
+		inner := in.Get(reqDesc.Fields().ByName("inner")).Message()
+		out.Set(replyDesc.Fields().ByName("name"), inner.Get(inner.Descriptor().Fields().ByName("name")))
+		incoming, _ = metadata.FromIncomingContext(ctx)
+		if tenant := incoming.Get("tenant"); len(tenant) > 0 {
+			out.Set(replyDesc.Fields().ByName("tenant"), protoreflect.ValueOfString(tenant[0]))
+		}
+		return out, nil
+	}
+	conn := grpcx.NewInProcessConn()
+	conn.RegisterService(&grpc.ServiceDesc{
+		ServiceName: string(sd.FullName()),
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "Get", Handler: handler},
+			{MethodName: "Post", Handler: handler},
+		},
+	}, struct{}{})
+
+	engine := gin.New()
+	require.NoError(t, NewGateway(conn, WithMaxBodySize(64)).RegisterService(engine, sd))
+
+	// 调用方身份与认证相关的 metadata 不能通过请求头伪造
+	recorder := serve(engine, http.MethodGet, "/v1/echo/hello?count=2&inner.name=tom", "", "Grpc-Metadata-Tenant", "t1",
+		"Grpc-Metadata-App", "admin", "Grpc-Metadata-X-Service-Token", "forged", "Grpc-Metadata-Client-Ip", "10.0.0.1")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"code":0,"msg":"","data":{"msg":"hello","count":2,"name":"tom","tenant":"t1"}}`, recorder.Body.String())
+	assert.Empty(t, incoming.Get("app"))
+	assert.Empty(t, incoming.Get("x-service-token"))
+	assert.Empty(t, incoming.Get("client-ip"))
+
+	// 请求体超过上限
+	recorder = serve(engine, http.MethodPost, "/v1/echo", `{"msg":"`+strings.Repeat("a", 64)+`"}`)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = serve(engine, http.MethodPost, "/v1/echo", `{"msg":"hello","count":3,"inner":{"name":"tom"}}`)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"code":0,"msg":"","data":{"msg":"hello","count":3,"name":"tom"}}`, recorder.Body.String())
+
+	// additional_bindings，body 对应 inner 字段，其余字段来自路径与 query
+	recorder = serve(engine, http.MethodPut, "/v1/echo/hi?count=1", `{"name":"jerry"}`)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"code":0,"msg":"","data":{"msg":"hi","count":1,"name":"jerry"}}`, recorder.Body.String())
+
+	// 业务错误
+	recorder = serve(engine, http.MethodPost, "/v1/echo", `{"count":-1}`)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.JSONEq(t, `{"code":100003,"msg":"count 不能小于 0","data":{"status":"INVALID_ARGUMENT","reason":"INVALID_COUNT"}}`, recorder.Body.String())
+
+	// query 中的字段类型错误
+	recorder = serve(engine, http.MethodGet, "/v1/echo/hello?count=abc", "")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestConvertTemplate(t *testing.T) {
+	testCases := []struct {
+		tpl     string
+		want    string
+		wantErr bool
+	}{
+		{tpl: "/v1/users", want: "/v1/users"},
+		{tpl: "/v1/users/{id}", want: "/v1/users/:id"},
+		{tpl: "/v1/users/{user.id}/orders/{order_id}", want: "/v1/users/:user.id/orders/:order_id"},
+		{tpl: "/v1/{name=users/*}", wantErr: true},
+		{tpl: "/v1/users/{id}:cancel", wantErr: true},
+		{tpl: "/v1/**", wantErr: true},
+		{tpl: "v1/users", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.tpl, func(t *testing.T) {
+			got, err := convertTemplate(tc.tpl)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package circuitbreaker
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-kratos/aegis/circuitbreaker"
+	"github.com/go-kratos/aegis/circuitbreaker/sre"
+	"github.com/to404hanga/pkg404/downgrade"
+)
+
+type Builder struct {
+	opts      []sre.Option
+	downgrade bool
+	breakers  sync.Map // route => circuitbreaker.CircuitBreaker
+}
+
+// NewBuilder 每个路由使用独立的 aegis SRE 熔断器，opts 用于配置熔断器参数
+func NewBuilder(opts ...sre.Option) *Builder {
+	return &Builder{
+		opts: opts,
+	}
+}
+
+// Downgrade 熔断时不拒绝请求，而是将请求标记为降级后继续执行，
+// 业务通过 downgrade.IsDowngraded(ctx.Request.Context()) 判断
+func (b *Builder) Downgrade() *Builder {
+	b.downgrade = true
+	return b
+}
+
+func (b *Builder) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		breaker := b.breaker(ctx)
+		if err := breaker.Allow(); err != nil {
+			breaker.MarkFailed()
+			if !b.downgrade {
+				ctx.AbortWithStatus(http.StatusServiceUnavailable)
+				return
+			}
+			ctx.Request = ctx.Request.WithContext(downgrade.WithDowngrade(ctx.Request.Context()))
+			ctx.Next()
+			return
+		}
+		ctx.Next()
+		if ctx.Writer.Status() >= http.StatusInternalServerError {
+			breaker.MarkFailed()
+		} else {
+			breaker.MarkSuccess()
+		}
+	}
+}
+
+func (b *Builder) breaker(ctx *gin.Context) circuitbreaker.CircuitBreaker {
+	route := ctx.Request.Method + " " + ctx.FullPath()
+	if val, ok := b.breakers.Load(route); ok {
+		return val.(circuitbreaker.CircuitBreaker)
+	}
+	val, _ := b.breakers.LoadOrStore(route, sre.NewBreaker(b.opts...))
+	return val.(circuitbreaker.CircuitBreaker)
+}
@@ -0,0 +1,69 @@
+package circuitbreaker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-kratos/aegis/circuitbreaker/sre"
+	"github.com/stretchr/testify/assert"
+	"github.com/to404hanga/pkg404/downgrade"
+)
+
+func TestBuilder_Build(t *testing.T) {
+	testCases := []struct {
+		name          string
+		downgrade     bool
+		wantStatus    int
+		wantDowngrade bool
+	}{
+		{
+			name:       "熔断后拒绝",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:          "熔断后降级",
+			downgrade:     true,
+			wantStatus:    http.StatusInternalServerError,
+			wantDowngrade: true,
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewBuilder(sre.WithRequest(10))
+			if tc.downgrade {
+				b.Downgrade()
+			}
+			engine := gin.New()
+			engine.Use(b.Build())
+			downgraded := false
+			engine.GET("/fail", func(ctx *gin.Context) {
+				downgraded = downgraded || downgrade.IsDowngraded(ctx.Request.Context())
+				ctx.Status(http.StatusInternalServerError)
+			})
+			engine.GET("/ok", func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+
+			status := make(map[int]int)
+			for i := 0; i < 200; i++ {
+				recorder := httptest.NewRecorder()
+				engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/fail", nil))
+				status[recorder.Code]++
+			}
+			assert.NotZero(t, status[tc.wantStatus])
+			assert.Equal(t, tc.wantDowngrade, downgraded)
+			if tc.downgrade {
+				assert.Zero(t, status[http.StatusServiceUnavailable])
+			}
+
+			// 每个路由使用独立的熔断器，/ok 不受 /fail 影响
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ok", nil))
+			assert.Equal(t, http.StatusOK, recorder.Code)
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package ratelimit
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/to404hanga/pkg404/limiter/bbr"
+)
+
+// BBRBuilder 根据 CPU 使用率与进行中的请求数自适应限流，系统过载时返回 429
+type BBRBuilder struct {
+	limiter *bbr.BBR
+}
+
+// NewBBRBuilder 同一个服务的所有路由应当共用一个 bbr.BBR
+func NewBBRBuilder(limiter *bbr.BBR) *BBRBuilder {
+	return &BBRBuilder{limiter: limiter}
+}
+
+func (b *BBRBuilder) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		done, err := b.limiter.Allow()
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+		defer done()
+		ctx.Next()
+	}
+}
@@ -8,12 +8,14 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/to404hanga/pkg404/downgrade"
 	"github.com/to404hanga/pkg404/limiter"
 )
 
 type Builder struct {
-	prefix  string
-	limiter limiter.Limiter
+	prefix    string
+	limiter   limiter.Limiter
+	downgrade bool
 }
 
 func NewBuilder(l limiter.Limiter) *Builder {
@@ -28,6 +30,13 @@ func (b *Builder) Prefix(prefix string) *Builder {
 	return b
 }
 
+// Downgrade 触发限流时不拒绝请求，而是将请求标记为降级后继续执行，
+// 业务通过 downgrade.IsDowngraded(ctx.Request.Context()) 判断
+func (b *Builder) Downgrade() *Builder {
+	b.downgrade = true
+	return b
+}
+
 func (b *Builder) Build() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		if ctx.GetHeader("x-stress") == "true" {
@@ -43,6 +52,11 @@ func (b *Builder) Build() gin.HandlerFunc {
 			return
 		}
 		if limited {
+			if b.downgrade {
+				ctx.Request = ctx.Request.WithContext(downgrade.WithDowngrade(ctx.Request.Context()))
+				ctx.Next()
+				return
+			}
 			log.Println(err)
 			ctx.AbortWithStatus(http.StatusTooManyRequests)
 			return
@@ -0,0 +1,155 @@
+package timeout
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/to404hanga/pkg404/errx"
+	"github.com/to404hanga/pkg404/ginx"
+	"google.golang.org/grpc/codes"
+)
+
+// HeaderRequestTimeout 客户端期望的超时时间，支持 time.Duration 格式（如 500ms）或毫秒数
+const HeaderRequestTimeout = "X-Request-Timeout"
+
+type Builder struct {
+	timeout    time.Duration
+	maxTimeout time.Duration
+	routes     map[string]time.Duration
+	status     int
+	result     ginx.Result
+}
+
+// NewBuilder timeout 为全局超时时间
+//
+// 默认的超时响应与其他框架错误一致，由 ginx.ErrorResult 根据 codes.DeadlineExceeded 生成
+func NewBuilder(timeout time.Duration) *Builder {
+	status, result := ginx.ErrorResult(errx.New(codes.DeadlineExceeded, "REQUEST_TIMEOUT", "请求超时"))
+	return &Builder{
+		timeout: timeout,
+		routes:  make(map[string]time.Duration),
+		status:  status,
+		result:  result,
+	}
+}
+
+// Route 为指定路由设置超时时间，path 为注册路由时的完整路径，如 /users/:id
+func (b *Builder) Route(method, path string, timeout time.Duration) *Builder {
+	b.routes[method+" "+path] = timeout
+	return b
+}
+
+// MaxTimeout 设置 X-Request-Timeout 允许的最大值，未设置时不能超过路由的超时时间
+func (b *Builder) MaxTimeout(timeout time.Duration) *Builder {
+	b.maxTimeout = timeout
+	return b
+}
+
+// Response 设置超时后返回的 HTTP 状态码与 Result
+func (b *Builder) Response(status int, result ginx.Result) *Builder {
+	b.status = status
+	b.result = result
+	return b
+}
+
+// Build 在请求的 context 上设置截止时间，超时后立即返回超时响应，业务之后的写入会被丢弃
+//
+// 为保证 gin.Context 不被提前回收，中间件会等待业务返回后才结束，业务应当尊重 ctx.Request.Context() 的截止时间
+func (b *Builder) Build() gin.HandlerFunc {
+	body, err := json.Marshal(b.result)
+	if err != nil {
+		panic(err)
+	}
+	return func(ctx *gin.Context) {
+		timeout := b.timeoutOf(ctx)
+		if timeout <= 0 {
+			ctx.Next()
+			return
+		}
+		reqCtx, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+		ctx.Request = ctx.Request.WithContext(reqCtx)
+
+		w := ctx.Writer
+		bw := newBufferedWriter(w)
+		ctx.Writer = bw
+
+		done := make(chan struct{})
+		panicCh := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicCh <- p
+				}
+				close(done)
+			}()
+			ctx.Next()
+		}()
+
+		select {
+		case <-done:
+			ctx.Writer = w
+			select {
+			case p := <-panicCh:
+				// 丢弃缓存的响应，交由外层的 Recovery 处理
+				panic(p)
+			default:
+			}
+			if bw.timeout() {
+				bw.flush()
+			}
+			return
+		case <-reqCtx.Done():
+			bw.timeout()
+			if reqCtx.Err() == context.DeadlineExceeded {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				w.WriteHeader(b.status)
+				_, _ = w.Write(body)
+				w.Flush()
+			}
+			<-done
+		}
+		ctx.Writer = w
+		select {
+		case p := <-panicCh:
+			panic(p)
+		default:
+		}
+	}
+}
+
+func (b *Builder) timeoutOf(ctx *gin.Context) time.Duration {
+	timeout := b.timeout
+	if d, ok := b.routes[ctx.Request.Method+" "+ctx.FullPath()]; ok {
+		timeout = d
+	}
+	maxTimeout := b.maxTimeout
+	if maxTimeout <= 0 {
+		maxTimeout = timeout
+	}
+	if d := parseTimeout(ctx.GetHeader(HeaderRequestTimeout)); d > 0 {
+		timeout = d
+		if maxTimeout > 0 && timeout > maxTimeout {
+			timeout = maxTimeout
+		}
+	}
+	return timeout
+}
+
+func parseTimeout(val string) time.Duration {
+	if val == "" {
+		return 0
+	}
+	if ms, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,106 @@
+package timeout
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/ginx"
+	"google.golang.org/grpc/codes"
+)
+
+func TestBuilder_Build(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(NewBuilder(50*time.Millisecond).
+		Route(http.MethodGet, "/slow/:id", 200*time.Millisecond).
+		MaxTimeout(100*time.Millisecond).
+		Build())
+	// sleep 模拟不尊重截止时间的业务，超时后仍然写响应
+	sleep := func(ctx *gin.Context) {
+		d, _ := time.ParseDuration(ctx.Query("sleep"))
+		time.Sleep(d)
+		ctx.Header("X-Biz", "true")
+		ctx.JSON(http.StatusOK, ginx.Result{Msg: "ok"})
+	}
+	engine.GET("/fast", sleep)
+	engine.GET("/slow/:id", sleep)
+	engine.GET("/deadline", func(ctx *gin.Context) {
+		deadline, ok := ctx.Request.Context().Deadline()
+		ctx.JSON(http.StatusOK, ginx.Result{Data: ok && time.Until(deadline) <= 20*time.Millisecond})
+	})
+
+	testCases := []struct {
+		name       string
+		url        string
+		header     string
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name:       "未超时",
+			url:        "/fast?sleep=1ms",
+			wantStatus: http.StatusOK,
+			wantMsg:    "ok",
+		},
+		{
+			name:       "全局超时",
+			url:        "/fast?sleep=100ms",
+			wantStatus: http.StatusGatewayTimeout,
+			wantMsg:    "请求超时",
+		},
+		{
+			name:       "路由超时覆盖全局超时",
+			url:        "/slow/1?sleep=100ms",
+			wantStatus: http.StatusOK,
+			wantMsg:    "ok",
+		},
+		{
+			name:       "请求头缩短超时",
+			url:        "/slow/1?sleep=50ms",
+			header:     "10ms",
+			wantStatus: http.StatusGatewayTimeout,
+			wantMsg:    "请求超时",
+		},
+		{
+			name:       "请求头不能超过上限",
+			url:        "/fast?sleep=150ms",
+			header:     "1000",
+			wantStatus: http.StatusGatewayTimeout,
+			wantMsg:    "请求超时",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.header != "" {
+				req.Header.Set(HeaderRequestTimeout, tc.header)
+			}
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantStatus, recorder.Code)
+			var res ginx.Result
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+			assert.Equal(t, tc.wantMsg, res.Msg)
+			if tc.wantStatus == http.StatusGatewayTimeout {
+				assert.Equal(t, ginx.ErrorCode(codes.DeadlineExceeded), res.Code)
+			}
+			assert.Equal(t, tc.wantStatus == http.StatusOK, recorder.Header().Get("X-Biz") == "true")
+		})
+	}
+
+	t.Run("截止时间传递到请求上下文", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/deadline", nil)
+		req.Header.Set(HeaderRequestTimeout, "20ms")
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		var res ginx.Result
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res))
+		assert.Equal(t, true, res.Data)
+	})
+}
@@ -0,0 +1,114 @@
+package timeout
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bufferedWriter 缓存业务写入的响应，超时后丢弃所有写入，避免与超时响应发生竞争
+type bufferedWriter struct {
+	gin.ResponseWriter
+	lock     sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	written  bool
+	timedOut bool
+}
+
+func newBufferedWriter(w gin.ResponseWriter) *bufferedWriter {
+	return &bufferedWriter{
+		ResponseWriter: w,
+		header:         make(http.Header),
+		status:         http.StatusOK,
+	}
+}
+
+func (w *bufferedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timedOut || w.written || code <= 0 {
+		return
+	}
+	w.status = code
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.written = true
+}
+
+func (w *bufferedWriter) Status() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.written
+}
+
+// Flush 缓存期间无法提前下发数据，忽略
+func (w *bufferedWriter) Flush() {
+}
+
+// timeout 标记超时，之后的写入全部丢弃，返回是否是首次标记
+func (w *bufferedWriter) timeout() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timedOut {
+		return false
+	}
+	w.timedOut = true
+	return true
+}
+
+// flush 将缓存的响应写入底层 writer
+func (w *bufferedWriter) flush() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	dst := w.ResponseWriter.Header()
+	for k, vs := range w.header {
+		dst[k] = vs
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.written {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+	if w.body.Len() > 0 {
+		_, _ = w.ResponseWriter.Write(w.body.Bytes())
+	}
+}
@@ -0,0 +1,604 @@
+package ginx
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/to404hanga/pkg404/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// OpenAPI OpenAPI 3 文档
+type OpenAPI struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       OpenAPIInfo         `json:"info"`
+	Servers    []OpenAPIServer     `json:"servers,omitempty"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components *Components         `json:"components,omitempty"`
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type OpenAPIServer struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem 小写的 HTTP 方法 => 操作
+type PathItem map[string]*Operation
+
+type Operation struct {
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	OperationID string               `json:"operationId,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+// JSON 将文档序列化为 JSON
+func (o *OpenAPI) JSON() ([]byte, error) {
+	return json.MarshalIndent(o, "", "  ")
+}
+
+// YAML 将文档序列化为 YAML
+func (o *OpenAPI) YAML() ([]byte, error) {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	// JSON 是 YAML 的子集，借助 yaml.Node 保留字段顺序
+	var node yaml.Node
+	if err = yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	blockStyle(&node)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err = enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err = enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// blockStyle 将从 JSON 解析出的 flow 风格转换为 block 风格
+func blockStyle(node *yaml.Node) {
+	node.Style &^= yaml.FlowStyle
+	if node.Kind == yaml.ScalarNode {
+		node.Style &^= yaml.DoubleQuotedStyle
+	}
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+type OpenAPIOption func(*OpenAPI)
+
+// WithInfo 设置文档标题与版本
+func WithInfo(title, version string) OpenAPIOption {
+	return func(o *OpenAPI) {
+		o.Info.Title = title
+		o.Info.Version = version
+	}
+}
+
+// WithInfoDescription 设置文档描述
+func WithInfoDescription(desc string) OpenAPIOption {
+	return func(o *OpenAPI) {
+		o.Info.Description = desc
+	}
+}
+
+// WithServers 设置服务地址
+func WithServers(urls ...string) OpenAPIOption {
+	return func(o *OpenAPI) {
+		for _, url := range urls {
+			o.Servers = append(o.Servers, OpenAPIServer{URL: url})
+		}
+	}
+}
+
+// GenerateOpenAPI 根据 routes 生成 OpenAPI 3 文档
+//
+// GET 请求的参数按 form 标签生成 query 参数，其余方法按 json 标签生成 JSON 请求体，
+// binding 标签中的 required、oneof、min、max、len 会被转换为对应的约束
+func GenerateOpenAPI(routes []RouteInfo, opts ...OpenAPIOption) *OpenAPI {
+	doc := &OpenAPI{
+		OpenAPI: "3.0.3",
+		Info: OpenAPIInfo{
+			Title:   "API",
+			Version: "1.0.0",
+		},
+		Paths: make(map[string]PathItem),
+	}
+	for _, opt := range opts {
+		opt(doc)
+	}
+	g := newSchemaGenerator()
+	for _, r := range routes {
+		p, pathParams := convertPath(r.Path)
+		item, ok := doc.Paths[p]
+		if !ok {
+			item = make(PathItem)
+			doc.Paths[p] = item
+		}
+		item[strings.ToLower(r.Method)] = g.operation(r, pathParams)
+	}
+	if len(g.schemas) > 0 {
+		doc.Components = &Components{Schemas: g.schemas}
+	}
+	return doc
+}
+
+// OpenAPIBuilder 收集通过 Router 或 WithOpenAPI 记录的路由并生成文档，文档在路由变化后首次使用时生成一次
+type OpenAPIBuilder struct {
+	opts []OpenAPIOption
+
+	lock   sync.Mutex
+	routes []RouteInfo
+	// doc、json 与 yaml 为当前路由生成的文档，路由变化时清空
+	doc  *OpenAPI
+	json []byte
+	yaml []byte
+}
+
+func NewOpenAPIBuilder(opts ...OpenAPIOption) *OpenAPIBuilder {
+	return &OpenAPIBuilder{opts: opts}
+}
+
+func (b *OpenAPIBuilder) add(info RouteInfo) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.routes = append(b.routes, info)
+	b.doc, b.json, b.yaml = nil, nil, nil
+}
+
+// Router 包装 r，之后通过它使用 Handle* 系列方法注册的路由都会记录到 b 中，无需为每个路由设置 WithOpenAPI
+func (b *OpenAPIBuilder) Router(r Router) *OpenAPIRouter {
+	return &OpenAPIRouter{Router: r, b: b}
+}
+
+// Routes 返回记录的所有路由
+func (b *OpenAPIBuilder) Routes() []RouteInfo {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]RouteInfo(nil), b.routes...)
+}
+
+// Build 返回的文档会被缓存，调用方不应修改
+func (b *OpenAPIBuilder) Build() *OpenAPI {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.build()
+}
+
+func (b *OpenAPIBuilder) build() *OpenAPI {
+	if b.doc == nil {
+		b.doc = GenerateOpenAPI(b.routes, b.opts...)
+	}
+	return b.doc
+}
+
+func (b *OpenAPIBuilder) marshal(yaml bool) ([]byte, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var err error
+	if yaml {
+		if b.yaml == nil {
+			b.yaml, err = b.build().YAML()
+		}
+		return b.yaml, err
+	}
+	if b.json == nil {
+		b.json, err = b.build().JSON()
+	}
+	return b.json, err
+}
+
+// Handler 返回输出 OpenAPI 文档的 gin.HandlerFunc
+//
+// 默认输出 JSON，请求路径以 .yaml/.yml 结尾或携带 format=yaml 参数时输出 YAML
+func (b *OpenAPIBuilder) Handler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		path := ctx.Request.URL.Path
+		if ctx.Query("format") == "yaml" || strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml") {
+			data, err := b.marshal(true)
+			if err != nil {
+				L.Error("生成 OpenAPI 文档失败", logger.Error(err))
+				ctx.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			ctx.Data(http.StatusOK, "application/yaml; charset=utf-8", data)
+			return
+		}
+		data, err := b.marshal(false)
+		if err != nil {
+			L.Error("生成 OpenAPI 文档失败", logger.Error(err))
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		ctx.Data(http.StatusOK, "application/json; charset=utf-8", data)
+	}
+}
+
+var pathParamRegexp = regexp.MustCompile(`[:*]([^/]+)`)
+
+// convertPath 将 gin 的 /users/:id 转换为 OpenAPI 的 /users/{id}
+func convertPath(p string) (string, []string) {
+	var params []string
+	res := pathParamRegexp.ReplaceAllStringFunc(p, func(s string) string {
+		params = append(params, s[1:])
+		return "{" + s[1:] + "}"
+	})
+	return res, params
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type schemaGenerator struct {
+	schemas map[string]*Schema
+	names   map[schemaKey]string
+}
+
+// schemaKey 同一个类型按 json 与 form 标签生成的字段名不同，需要分别注册
+type schemaKey struct {
+	t      reflect.Type
+	tagKey string
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{
+		schemas: make(map[string]*Schema),
+		names:   make(map[schemaKey]string),
+	}
+}
+
+func (g *schemaGenerator) operation(r RouteInfo, pathParams []string) *Operation {
+	op := &Operation{
+		Summary:     r.Summary,
+		Description: r.Description,
+		Tags:        r.Tags,
+		OperationID: operationID(r.Method, r.Path),
+		Responses:   make(map[string]*Response),
+	}
+	for _, name := range pathParams {
+		op.Parameters = append(op.Parameters, &Parameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+	if r.Req != nil {
+		if bindsQuery(r.Method) {
+			op.Parameters = append(op.Parameters, g.queryParameters(r.Req)...)
+		} else {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content: map[string]*MediaType{
+					"application/json": {Schema: g.schema(r.Req, "json")},
+				},
+			}
+		}
+	}
+	data := &Schema{}
+	if r.Resp != nil && r.Resp.Kind() != reflect.Interface {
+		data = g.schema(r.Resp, "json")
+	}
+	op.Responses["200"] = &Response{
+		Description: "OK",
+		Content: map[string]*MediaType{
+			"application/json": {Schema: &Schema{
+				Type: "object",
+				Properties: map[string]*Schema{
+					"code": {Type: "integer"},
+					"msg":  {Type: "string"},
+					"data": data,
+				},
+			}},
+		},
+	}
+	if r.Auth {
+		op.Responses["401"] = &Response{Description: "Unauthorized"}
+	}
+	return op
+}
+
+func operationID(method, p string) string {
+	var sb strings.Builder
+	sb.WriteString(strings.ToLower(method))
+	for _, seg := range strings.FieldsFunc(p, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		sb.WriteString(strings.ToUpper(seg[:1]) + seg[1:])
+	}
+	return sb.String()
+}
+
+// queryParameters 将请求结构体的字段展开为 query 参数
+func (g *schemaGenerator) queryParameters(t reflect.Type) []*Parameter {
+	t = indirect(t)
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var res []*Parameter
+	eachField(t, "form", func(f reflect.StructField, name string) {
+		s := g.schema(f.Type, "form")
+		required := applyBinding(s, f.Tag.Get("binding"))
+		res = append(res, &Parameter{
+			Name:     name,
+			In:       "query",
+			Required: required,
+			Schema:   s,
+		})
+	})
+	return res
+}
+
+func (g *schemaGenerator) schema(t reflect.Type, tagKey string) *Schema {
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schema(t.Elem(), tagKey)
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schema(t.Elem(), tagKey)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem(), tagKey)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t, tagKey)
+		}
+		return &Schema{Ref: "#/components/schemas/" + g.component(t, tagKey)}
+	default:
+		// interface 等无法确定结构的类型
+		return &Schema{}
+	}
+}
+
+// component 将具名结构体注册到 components 中并返回其名称，按 json 以外的标签生成时名称带有标签后缀，如 User_form
+func (g *schemaGenerator) component(t reflect.Type, tagKey string) string {
+	key := schemaKey{t: t, tagKey: tagKey}
+	if name, ok := g.names[key]; ok {
+		return name
+	}
+	name := schemaName(t)
+	if tagKey != "json" {
+		name += "_" + tagKey
+	}
+	if _, ok := g.schemas[name]; ok {
+		// 不同包下的同名类型，使用包名区分
+		pkg := t.PkgPath()
+		name = sanitizeName(pkg[strings.LastIndex(pkg, "/")+1:]) + "." + name
+		if _, ok = g.schemas[name]; ok {
+			name += "_" + strconv.Itoa(len(g.schemas))
+		}
+	}
+	g.names[key] = name
+	// 先占位，避免递归类型无限展开
+	g.schemas[name] = &Schema{}
+	*g.schemas[name] = *g.structSchema(t, tagKey)
+	return name
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type, tagKey string) *Schema {
+	s := &Schema{
+		Type:       "object",
+		Properties: make(map[string]*Schema),
+	}
+	eachField(t, tagKey, func(f reflect.StructField, name string) {
+		fs := g.schema(f.Type, tagKey)
+		if applyBinding(fs, f.Tag.Get("binding")) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = fs
+	})
+	sort.Strings(s.Required)
+	return s
+}
+
+// eachField 遍历可导出字段，匿名嵌入的结构体会被展开
+func eachField(t reflect.Type, tagKey string, fn func(f reflect.StructField, name string)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get(tagKey)
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := indirect(f.Type)
+			if ft.Kind() == reflect.Struct {
+				eachField(ft, tagKey, fn)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fn(f, name)
+	}
+}
+
+// applyBinding 将 binding 标签转换为约束，返回是否必填
+func applyBinding(s *Schema, binding string) bool {
+	if binding == "" {
+		return false
+	}
+	required := false
+	for _, rule := range strings.Split(binding, ",") {
+		key, val, _ := strings.Cut(rule, "=")
+		switch key {
+		case "required":
+			required = true
+		case "oneof":
+			for _, v := range strings.Fields(val) {
+				s.Enum = append(s.Enum, enumValue(s.Type, v))
+			}
+		case "min", "gte":
+			setBound(s, val, true)
+		case "max", "lte":
+			setBound(s, val, false)
+		case "len":
+			setBound(s, val, true)
+			setBound(s, val, false)
+		}
+	}
+	return required
+}
+
+func setBound(s *Schema, val string, lower bool) {
+	switch s.Type {
+	case "integer", "number":
+		v, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return
+		}
+		if lower {
+			s.Minimum = &v
+		} else {
+			s.Maximum = &v
+		}
+	case "string", "array":
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return
+		}
+		switch {
+		case s.Type == "string" && lower:
+			s.MinLength = &v
+		case s.Type == "string":
+			s.MaxLength = &v
+		case lower:
+			s.MinItems = &v
+		default:
+			s.MaxItems = &v
+		}
+	}
+}
+
+func enumValue(typ, val string) any {
+	switch typ {
+	case "integer":
+		if v, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return v
+		}
+	case "number":
+		if v, err := strconv.ParseFloat(val, 64); err == nil {
+			return v
+		}
+	}
+	return val
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+func schemaName(t reflect.Type) string {
+	return sanitizeName(t.Name())
+}
+
+var invalidNameRegexp = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sanitizeName 处理泛型类型名，如 Page[github.com/x/y.User] => Page_y.User
+func sanitizeName(name string) string {
+	if idx := strings.Index(name, "["); idx >= 0 {
+		args := strings.Split(strings.TrimSuffix(name[idx+1:], "]"), ",")
+		for i, arg := range args {
+			if j := strings.LastIndex(arg, "/"); j >= 0 {
+				args[i] = arg[j+1:]
+			}
+		}
+		name = name[:idx] + "_" + strings.Join(args, "_")
+	}
+	return strings.Trim(invalidNameRegexp.ReplaceAllString(name, "_"), "_")
+}
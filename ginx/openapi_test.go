@@ -0,0 +1,177 @@
+package ginx
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type openAPIBase struct {
+	TraceID string `json:"trace_id" form:"trace_id"`
+}
+
+type openAPIUser struct {
+	ID        int64          `json:"id"`
+	Name      string         `json:"name"`
+	Tags      []string       `json:"tags,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	Friend    *openAPIUser   `json:"friend"`
+	Extra     map[string]any `json:"extra"`
+	secret    string
+}
+
+type openAPICreateUserReq struct {
+	openAPIBase
+	Name     string `json:"name" binding:"required,min=2,max=32"`
+	Gender   string `json:"gender" binding:"oneof=male female"`
+	Age      *int   `json:"age"`
+	Password string `json:"-"`
+}
+
+type openAPIListUserReq struct {
+	Page int    `form:"page" binding:"required,min=1"`
+	Size int    `form:"size" binding:"max=100"`
+	Key  string `form:"key"`
+}
+
+func TestGenerateOpenAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	b := NewOpenAPIBuilder(WithInfo("test", "v1"))
+	// 通过 b.Router 注册的路由默认被记录
+	group := b.Router(engine).Group("/openapi/users")
+	HandleBody[openAPICreateUserReq, openAPIUser](group, http.MethodPost, "", func(ctx *gin.Context, req openAPICreateUserReq) (Result, error) {
+		return Result{}, nil
+	}, WithSummary("创建用户"), WithTags("user"))
+	HandleBody[openAPIListUserReq, []openAPIUser](group, http.MethodGet, "", func(ctx *gin.Context, req openAPIListUserReq) (Result, error) {
+		return Result{}, nil
+	})
+	HandleBody[openAPIListUserReq, any](group, http.MethodDelete, "", func(ctx *gin.Context, req openAPIListUserReq) (Result, error) {
+		return Result{}, nil
+	})
+	Handle[string](group, http.MethodGet, "/internal", func(ctx *gin.Context) (Result, error) {
+		return Result{}, nil
+	}, WithOpenAPI(nil))
+	// 其他 Router 上的路由通过 WithOpenAPI 记录
+	HandleClaims[map[string]any, openAPIUser](engine.Group("/openapi/users"), http.MethodGet, "/:id", func(ctx *gin.Context, claims map[string]any) (Result, error) {
+		return Result{}, nil
+	}, WithOpenAPI(b))
+
+	// 没有 WithOpenAPI 的路由不会被记录
+	Handle[string](engine, http.MethodGet, "/openapi/internal", func(ctx *gin.Context) (Result, error) {
+		return Result{}, nil
+	})
+	assert.Len(t, b.Routes(), 4)
+	assert.NotContains(t, b.Build().Paths, "/openapi/users/internal")
+
+	doc := b.Build()
+	assert.Same(t, doc, b.Build())
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+	assert.Equal(t, "test", doc.Info.Title)
+
+	create := doc.Paths["/openapi/users"]["post"]
+	require.NotNil(t, create)
+	assert.Equal(t, "创建用户", create.Summary)
+	assert.Equal(t, []string{"user"}, create.Tags)
+	reqSchema := doc.Components.Schemas[create.RequestBody.Content["application/json"].Schema.Ref[len("#/components/schemas/"):]]
+	require.NotNil(t, reqSchema)
+	assert.Equal(t, []string{"name"}, reqSchema.Required)
+	assert.Contains(t, reqSchema.Properties, "trace_id")
+	assert.NotContains(t, reqSchema.Properties, "Password")
+	assert.Equal(t, 2, *reqSchema.Properties["name"].MinLength)
+	assert.Equal(t, 32, *reqSchema.Properties["name"].MaxLength)
+	assert.Equal(t, []any{"male", "female"}, reqSchema.Properties["gender"].Enum)
+	assert.True(t, reqSchema.Properties["age"].Nullable)
+
+	data := create.Responses["200"].Content["application/json"].Schema.Properties["data"]
+	assert.Equal(t, "#/components/schemas/openAPIUser", data.Ref)
+	user := doc.Components.Schemas["openAPIUser"]
+	assert.Equal(t, "date-time", user.Properties["created_at"].Format)
+	assert.Equal(t, "#/components/schemas/openAPIUser", user.Properties["friend"].Ref)
+	assert.Equal(t, "object", user.Properties["extra"].Type)
+	assert.NotContains(t, user.Properties, "secret")
+
+	list := doc.Paths["/openapi/users"]["get"]
+	require.NotNil(t, list)
+	assert.Nil(t, list.RequestBody)
+	require.Len(t, list.Parameters, 3)
+	assert.Equal(t, "page", list.Parameters[0].Name)
+	assert.Equal(t, "query", list.Parameters[0].In)
+	assert.True(t, list.Parameters[0].Required)
+	assert.Equal(t, float64(100), *list.Parameters[1].Schema.Maximum)
+	assert.Equal(t, "array", list.Responses["200"].Content["application/json"].Schema.Properties["data"].Type)
+
+	// 与 ctx.Bind 一致，只有 GET 从 query 中解析
+	remove := doc.Paths["/openapi/users"]["delete"]
+	require.NotNil(t, remove)
+	assert.Empty(t, remove.Parameters)
+	assert.NotNil(t, remove.RequestBody)
+
+	detail := doc.Paths["/openapi/users/{id}"]["get"]
+	require.NotNil(t, detail)
+	require.Len(t, detail.Parameters, 1)
+	assert.Equal(t, "path", detail.Parameters[0].In)
+	assert.Contains(t, detail.Responses, "401")
+}
+
+type openAPIFilter struct {
+	MinAge int `json:"min_age" form:"min"`
+}
+
+type openAPISearchReq struct {
+	Filter openAPIFilter `form:"filter"`
+}
+
+func TestGenerateOpenAPI_TagKey(t *testing.T) {
+	// 同一个类型先出现在 query 中，再出现在响应中，响应使用 json 标签的字段名
+	doc := GenerateOpenAPI([]RouteInfo{
+		{Method: http.MethodGet, Path: "/search", Req: typeOf[openAPISearchReq](), Resp: typeOf[openAPIFilter]()},
+	})
+	op := doc.Paths["/search"]["get"]
+	require.NotNil(t, op)
+	require.Len(t, op.Parameters, 1)
+	assert.Equal(t, "#/components/schemas/openAPIFilter_form", op.Parameters[0].Schema.Ref)
+	assert.Contains(t, doc.Components.Schemas["openAPIFilter_form"].Properties, "min")
+
+	data := op.Responses["200"].Content["application/json"].Schema.Properties["data"]
+	assert.Equal(t, "#/components/schemas/openAPIFilter", data.Ref)
+	assert.Contains(t, doc.Components.Schemas["openAPIFilter"].Properties, "min_age")
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	b := NewOpenAPIBuilder()
+	Handle[string](engine, http.MethodGet, "/openapi/ping", func(ctx *gin.Context) (Result, error) {
+		return Result{Data: "pong"}, nil
+	}, WithOpenAPI(b))
+	engine.GET("/openapi.json", b.Handler())
+	engine.GET("/openapi.yaml", b.Handler())
+
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var doc OpenAPI
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &doc))
+	assert.Contains(t, doc.Paths, "/openapi/ping")
+
+	recorder = httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.yaml", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "openapi: 3.0.3")
+
+	// 新增路由后重新生成
+	Handle[string](engine, http.MethodGet, "/openapi/pong", func(ctx *gin.Context) (Result, error) {
+		return Result{Data: "ping"}, nil
+	}, WithOpenAPI(b))
+	recorder = httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &doc))
+	assert.Contains(t, doc.Paths, "/openapi/pong")
+}
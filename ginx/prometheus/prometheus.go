@@ -6,6 +6,15 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/to404hanga/pkg404/ginx"
+	"github.com/to404hanga/pkg404/prometheusx"
+)
+
+var (
+	// DefaultBuckets 响应时间的默认分桶，单位毫秒
+	DefaultBuckets = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+	// DefaultSizeBuckets 请求与响应大小的默认分桶，单位字节
+	DefaultSizeBuckets = prometheus.ExponentialBuckets(64, 4, 8)
 )
 
 type Builder struct {
@@ -14,54 +23,112 @@ type Builder struct {
 	Name       string
 	InstanceId string
 	Help       string
+	// Buckets 响应时间的分桶，单位毫秒，为空时使用 DefaultBuckets
+	Buckets []float64
+	// SizeBuckets 请求与响应大小的分桶，单位字节，为空时使用 DefaultSizeBuckets
+	SizeBuckets []float64
+	// Registerer 为空时使用 prometheus.DefaultRegisterer
+	Registerer prometheus.Registerer
 }
 
+// BuildResponseTime 按 method、pattern、status 以及 ginx.Result 的 code 统计响应时间
 func (b *Builder) BuildResponseTime() gin.HandlerFunc {
-	labels := []string{"method", "pattern", "status"}
-	vector := prometheus.NewSummaryVec(prometheus.SummaryOpts{
-		Namespace: b.Namespace,
-		Subsystem: b.Subsystem,
-		Name:      b.Name + "_reps_time",
-		Help:      b.Help,
-		ConstLabels: map[string]string{
-			"instance_id": b.InstanceId,
-		},
-		Objectives: map[float64]float64{
-			0.5:   0.01,
-			0.75:  0.01,
-			0.9:   0.01,
-			0.99:  0.001,
-			0.999: 0.0001,
-		},
-	}, labels)
-	prometheus.MustRegister(vector)
+	labels := []string{"method", "pattern", "status", "code"}
+	buckets := b.Buckets
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	vector := prometheusx.Register(b.registerer(), prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   b.Namespace,
+		Subsystem:   b.Subsystem,
+		Name:        b.Name + "_reps_time",
+		Help:        b.Help,
+		ConstLabels: b.constLabels(),
+		Buckets:     buckets,
+	}, labels))
 	return func(ctx *gin.Context) {
 		start := time.Now()
 		defer func() {
 			duration := time.Since(start).Milliseconds()
-			method := ctx.Request.Method
-			pattern := ctx.FullPath()
-			status := ctx.Writer.Status()
-			vector.WithLabelValues(method, pattern, strconv.Itoa(status)).Observe(float64(duration))
+			vector.WithLabelValues(b.labelValues(ctx)...).Observe(float64(duration))
+		}()
+		ctx.Next()
+	}
+}
+
+// BuildSize 按 method、pattern、status 以及 ginx.Result 的 code 统计请求与响应的大小
+func (b *Builder) BuildSize() gin.HandlerFunc {
+	labels := []string{"method", "pattern", "status", "code"}
+	buckets := b.SizeBuckets
+	if len(buckets) == 0 {
+		buckets = DefaultSizeBuckets
+	}
+	reqVector := prometheusx.Register(b.registerer(), prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   b.Namespace,
+		Subsystem:   b.Subsystem,
+		Name:        b.Name + "_req_size",
+		Help:        b.Help,
+		ConstLabels: b.constLabels(),
+		Buckets:     buckets,
+	}, labels))
+	respVector := prometheusx.Register(b.registerer(), prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   b.Namespace,
+		Subsystem:   b.Subsystem,
+		Name:        b.Name + "_resp_size",
+		Help:        b.Help,
+		ConstLabels: b.constLabels(),
+		Buckets:     buckets,
+	}, labels))
+	return func(ctx *gin.Context) {
+		defer func() {
+			labelValues := b.labelValues(ctx)
+			reqSize := ctx.Request.ContentLength
+			if reqSize < 0 {
+				reqSize = 0
+			}
+			reqVector.WithLabelValues(labelValues...).Observe(float64(reqSize))
+			respSize := ctx.Writer.Size()
+			if respSize < 0 {
+				respSize = 0
+			}
+			respVector.WithLabelValues(labelValues...).Observe(float64(respSize))
 		}()
 		ctx.Next()
 	}
 }
 
 func (b *Builder) BuildActiveRequest() gin.HandlerFunc {
-	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: b.Namespace,
-		Subsystem: b.Subsystem,
-		Name:      b.Name + "_active_req",
-		Help:      b.Help,
-		ConstLabels: map[string]string{
-			"instance_id": b.InstanceId,
-		},
-	})
-	prometheus.MustRegister(gauge)
+	gauge := prometheusx.Register(b.registerer(), prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   b.Namespace,
+		Subsystem:   b.Subsystem,
+		Name:        b.Name + "_active_req",
+		Help:        b.Help,
+		ConstLabels: b.constLabels(),
+	}))
 	return func(ctx *gin.Context) {
 		gauge.Inc()
 		defer gauge.Dec()
 		ctx.Next()
 	}
 }
+
+func (b *Builder) labelValues(ctx *gin.Context) []string {
+	code := ctx.GetString(ginx.ResultCodeKey)
+	if code == "" {
+		code = "none"
+	}
+	return []string{ctx.Request.Method, ctx.FullPath(), strconv.Itoa(ctx.Writer.Status()), code}
+}
+
+func (b *Builder) constLabels() prometheus.Labels {
+	return prometheus.Labels{
+		"instance_id": b.InstanceId,
+	}
+}
+
+func (b *Builder) registerer() prometheus.Registerer {
+	if b.Registerer == nil {
+		return prometheus.DefaultRegisterer
+	}
+	return b.Registerer
+}
@@ -0,0 +1,73 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/ginx"
+)
+
+func TestBuilder(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	b := &Builder{
+		Namespace:  "pkg404",
+		Subsystem:  "ginx",
+		Name:       "test",
+		InstanceId: "1",
+		Help:       "test",
+		Buckets:    []float64{1, 10, 100},
+		Registerer: reg,
+	}
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	// 重复构建不会 panic
+	for i := 0; i < 2; i++ {
+		engine.Use(b.BuildResponseTime(), b.BuildSize(), b.BuildActiveRequest())
+	}
+	engine.POST("/users/:id", ginx.Wrap(func(ctx *gin.Context) (ginx.Result, error) {
+		return ginx.Result{Code: 4001, Msg: "参数错误"}, nil
+	}))
+
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/users/1", strings.NewReader(`{"name":"a"}`)))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	got := make(map[string]map[string]string)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			got[mf.GetName()] = labels
+			switch mf.GetName() {
+			case "pkg404_ginx_test_reps_time":
+				assert.Equal(t, uint64(2), m.GetHistogram().GetSampleCount())
+				assert.Len(t, m.GetHistogram().GetBucket(), 3)
+			case "pkg404_ginx_test_req_size":
+				assert.Equal(t, float64(2*len(`{"name":"a"}`)), m.GetHistogram().GetSampleSum())
+			case "pkg404_ginx_test_resp_size":
+				assert.NotZero(t, m.GetHistogram().GetSampleSum())
+			}
+		}
+	}
+	require.Contains(t, got, "pkg404_ginx_test_reps_time")
+	require.Contains(t, got, "pkg404_ginx_test_req_size")
+	require.Contains(t, got, "pkg404_ginx_test_resp_size")
+	require.Contains(t, got, "pkg404_ginx_test_active_req")
+	assert.Equal(t, map[string]string{
+		"instance_id": "1",
+		"method":      http.MethodPost,
+		"pattern":     "/users/:id",
+		"status":      "200",
+		"code":        "4001",
+	}, got["pkg404_ginx_test_reps_time"])
+}
@@ -1,7 +1,67 @@
 package ginx
 
+import (
+	"net/http"
+	"strings"
+
+	"github.com/to404hanga/pkg404/errx"
+	"google.golang.org/grpc/codes"
+)
+
 type Result struct {
 	Code int    `json:"code"`
 	Msg  string `json:"msg"`
 	Data any    `json:"data"`
 }
+
+// ErrorCodeBase errx.Error 对应的 Result.Code 为 ErrorCodeBase 加上 gRPC 状态码，
+// 业务自定义的 Result.Code 不应使用 [ErrorCodeBase, ErrorCodeBase+100) 区间
+var ErrorCodeBase = 100000
+
+// ErrorCode gRPC 状态码对应的 Result.Code
+func ErrorCode(code codes.Code) int {
+	return ErrorCodeBase + int(code)
+}
+
+// ErrorData ErrorResult 中 Result.Data 的内容，Status 为 gRPC 状态码的名称，如 NOT_FOUND
+type ErrorData struct {
+	Status   string            `json:"status"`
+	Reason   string            `json:"reason"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ErrorResult 将错误转换为 Result 与对应的 HTTP 状态码，Result.Code 由 ErrorCode 得到，
+// 不会与业务自定义的 Result.Code 冲突
+//
+// 非 errx.Error 的错误按 errx.FromError 的规则转换
+func ErrorResult(err error) (int, Result) {
+	e := errx.FromError(err)
+	if e == nil {
+		return http.StatusOK, Result{}
+	}
+	return e.HTTPStatus(), Result{
+		Code: ErrorCode(e.Code),
+		Msg:  e.Message,
+		Data: ErrorData{
+			Status:   statusName(e.Code),
+			Reason:   e.Reason,
+			Metadata: e.Metadata,
+		},
+	}
+}
+
+// statusName 将 codes.NotFound 转换为 NOT_FOUND
+func statusName(code codes.Code) string {
+	var (
+		b    strings.Builder
+		prev rune
+	)
+	for _, r := range code.String() {
+		if prev >= 'a' && prev <= 'z' && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+		prev = r
+	}
+	return strings.ToUpper(b.String())
+}
@@ -0,0 +1,141 @@
+package ginx
+
+import (
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Router 可以注册路由并获取路由前缀，*gin.Engine 与 *gin.RouterGroup 均满足
+type Router interface {
+	gin.IRoutes
+	BasePath() string
+}
+
+// RouteInfo 记录通过 Handle* 系列方法注册的路由，用于生成 OpenAPI 文档，
+// 通过 OpenAPIBuilder.Router 包装的 Router 注册的路由会被记录，其他路由需要通过 WithOpenAPI 指定
+type RouteInfo struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Tags        []string
+	// Req 请求类型，为 nil 表示没有请求参数
+	Req reflect.Type
+	// Resp Result.Data 的类型，为 nil 表示不声明 data 结构
+	Resp reflect.Type
+	// Auth 是否需要登录态（即通过 Claims 包装）
+	Auth bool
+
+	openapi *OpenAPIBuilder
+}
+
+type RouteOption func(*RouteInfo)
+
+// WithSummary 设置接口摘要
+func WithSummary(summary string) RouteOption {
+	return func(r *RouteInfo) {
+		r.Summary = summary
+	}
+}
+
+// WithDescription 设置接口描述
+func WithDescription(desc string) RouteOption {
+	return func(r *RouteInfo) {
+		r.Description = desc
+	}
+}
+
+// WithTags 设置接口分组
+func WithTags(tags ...string) RouteOption {
+	return func(r *RouteInfo) {
+		r.Tags = append(r.Tags, tags...)
+	}
+}
+
+// WithOpenAPI 将路由记录到 b 中，用于生成 OpenAPI 文档，覆盖 OpenAPIBuilder.Router 的设置，b 为 nil 时不记录
+func WithOpenAPI(b *OpenAPIBuilder) RouteOption {
+	return func(r *RouteInfo) {
+		r.openapi = b
+	}
+}
+
+// HandleBody 使用 WrapBody 注册路由，并记录 Req 与 Resp 类型
+func HandleBody[Req any, Resp any](r Router, method, relativePath string, bizFunc func(ctx *gin.Context, req Req) (Result, error), opts ...RouteOption) gin.IRoutes {
+	record(r, method, relativePath, typeOf[Req](), typeOf[Resp](), false, opts)
+	return r.Handle(method, relativePath, WrapBody(bizFunc))
+}
+
+// HandleBodyAndClaims 使用 WrapBodyAndClaims 注册路由，并记录 Req 与 Resp 类型
+func HandleBodyAndClaims[Req any, Claims any, Resp any](r Router, method, relativePath string, bizFunc func(ctx *gin.Context, req Req, claims Claims) (Result, error), opts ...RouteOption) gin.IRoutes {
+	record(r, method, relativePath, typeOf[Req](), typeOf[Resp](), true, opts)
+	return r.Handle(method, relativePath, WrapBodyAndClaims(bizFunc))
+}
+
+// HandleClaims 使用 WrapClaims 注册路由，并记录 Resp 类型
+func HandleClaims[Claims any, Resp any](r Router, method, relativePath string, bizFunc func(ctx *gin.Context, claims Claims) (Result, error), opts ...RouteOption) gin.IRoutes {
+	record(r, method, relativePath, nil, typeOf[Resp](), true, opts)
+	return r.Handle(method, relativePath, WrapClaims(bizFunc))
+}
+
+// Handle 使用 Wrap 注册路由，并记录 Resp 类型
+func Handle[Resp any](r Router, method, relativePath string, bizFunc func(ctx *gin.Context) (Result, error), opts ...RouteOption) gin.IRoutes {
+	record(r, method, relativePath, nil, typeOf[Resp](), false, opts)
+	return r.Handle(method, relativePath, Wrap(bizFunc))
+}
+
+func record(r Router, method, relativePath string, req, resp reflect.Type, auth bool, opts []RouteOption) {
+	info := RouteInfo{
+		Method: strings.ToUpper(method),
+		Path:   joinPaths(r.BasePath(), relativePath),
+		Req:    req,
+		Resp:   resp,
+		Auth:   auth,
+	}
+	if or, ok := r.(*OpenAPIRouter); ok {
+		info.openapi = or.b
+	}
+	for _, opt := range opts {
+		opt(&info)
+	}
+	if info.openapi != nil {
+		b := info.openapi
+		info.openapi = nil
+		b.add(info)
+	}
+}
+
+// OpenAPIRouter 通过它使用 Handle* 系列方法注册的路由默认记录到 OpenAPIBuilder 中
+type OpenAPIRouter struct {
+	Router
+	b *OpenAPIBuilder
+}
+
+// Group 创建的路由组同样会被记录，Router 需要为 *gin.Engine 或 *gin.RouterGroup
+func (r *OpenAPIRouter) Group(relativePath string, handlers ...gin.HandlerFunc) *OpenAPIRouter {
+	return &OpenAPIRouter{Router: r.Router.(gin.IRouter).Group(relativePath, handlers...), b: r.b}
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func joinPaths(base, relative string) string {
+	if relative == "" {
+		return base
+	}
+	res := path.Join(base, relative)
+	if strings.HasSuffix(relative, "/") && !strings.HasSuffix(res, "/") {
+		res += "/"
+	}
+	return res
+}
+
+// bindsQuery 判断 ctx.Bind 对该方法是否从 query 中解析参数，与 binding.Default 一致，
+// 只有 GET 使用 query，其他方法按 Content-Type 从请求体中解析，文档中的请求体为 JSON
+func bindsQuery(method string) bool {
+	return binding.Default(method, binding.MIMEJSON) == binding.Form
+}
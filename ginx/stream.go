@@ -0,0 +1,215 @@
+package ginx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/to404hanga/pkg404/errx"
+	"github.com/to404hanga/pkg404/logger"
+)
+
+const (
+	// StreamResultEvent 流结束时携带 Result 的事件名
+	StreamResultEvent = "result"
+	// streamCanceledCode 客户端断开时计数使用的 code
+	streamCanceledCode = "canceled"
+)
+
+// ErrStreamClosed WrapStream 返回后继续推送事件
+var ErrStreamClosed = errors.New("ginx: stream closed")
+
+// ErrInvalidEvent 事件的 ID 或事件名中包含换行，会破坏事件的边界
+var ErrInvalidEvent = errors.New("ginx: event id and name must not contain CR or LF")
+
+// Event Server-Sent Events 事件
+type Event[T any] struct {
+	// ID 与 Event 不能包含 CR 与 LF
+	ID    string
+	Event string
+	// Data T 为 string 与 []byte 时原样输出，其他类型序列化为 JSON
+	Data  T
+	Retry time.Duration
+}
+
+// Emitter 向客户端推送事件，可以被多个 goroutine 并发使用
+type Emitter[T any] struct {
+	ctx    *gin.Context
+	lock   sync.Mutex
+	closed bool
+}
+
+// Send 推送一个只包含 data 的事件，客户端断开后返回 context 的错误
+func (e *Emitter[T]) Send(data T) error {
+	return e.SendEvent(Event[T]{Data: data})
+}
+
+// SendEvent 推送事件，客户端断开后返回 context 的错误
+func (e *Emitter[T]) SendEvent(evt Event[T]) error {
+	frame, err := encodeEvent(evt)
+	if err != nil {
+		return err
+	}
+	return e.write(frame)
+}
+
+func (e *Emitter[T]) heartbeat() error {
+	return e.write([]byte(": ping\n\n"))
+}
+
+func (e *Emitter[T]) write(frame []byte) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return ErrStreamClosed
+	}
+	if err := e.ctx.Request.Context().Err(); err != nil {
+		return err
+	}
+	if _, err := e.ctx.Writer.Write(frame); err != nil {
+		return err
+	}
+	e.ctx.Writer.Flush()
+	return nil
+}
+
+func (e *Emitter[T]) close() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.closed = true
+}
+
+// lineBreaks SSE 中 CRLF、CR 与 LF 都是换行
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+func encodeEvent[T any](evt Event[T]) ([]byte, error) {
+	if strings.ContainsAny(evt.ID, "\r\n") || strings.ContainsAny(evt.Event, "\r\n") {
+		return nil, ErrInvalidEvent
+	}
+	var data string
+	switch val := any(evt.Data).(type) {
+	case string:
+		data = val
+	case []byte:
+		data = string(val)
+	default:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+		data = string(b)
+	}
+	var buf bytes.Buffer
+	if evt.ID != "" {
+		buf.WriteString("id: " + evt.ID + "\n")
+	}
+	if evt.Event != "" {
+		buf.WriteString("event: " + evt.Event + "\n")
+	}
+	if evt.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(evt.Retry.Milliseconds(), 10) + "\n")
+	}
+	for _, line := range strings.Split(lineBreaks.Replace(data), "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+type streamOptions struct {
+	heartbeat time.Duration
+}
+
+type StreamOption func(*streamOptions)
+
+// WithHeartbeat 设置心跳间隔，小于等于 0 时不发送心跳，默认 15s
+func WithHeartbeat(interval time.Duration) StreamOption {
+	return func(o *streamOptions) {
+		o.heartbeat = interval
+	}
+}
+
+// WrapStream 以 Server-Sent Events 的形式返回数据
+//
+// 业务通过 emitter 推送类型为 T 的事件，返回的 Result 会作为最后一个 result 事件下发。
+// 客户端断开时 ctx.Request.Context() 会被取消，emitter 的写入会返回错误
+func WrapStream[Req any, T any](bizFunc func(ctx *gin.Context, req Req, emitter *Emitter[T]) (Result, error), opts ...StreamOption) gin.HandlerFunc {
+	o := &streamOptions{
+		heartbeat: 15 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return func(ctx *gin.Context) {
+		var req Req
+		if err := ctx.Bind(&req); err != nil {
+			L.Error("输入错误", logger.Error(err))
+			return
+		}
+		L.Debug("输入参数", logger.Any("req", req))
+
+		header := ctx.Writer.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+		header.Set("X-Accel-Buffering", "no")
+		ctx.Status(http.StatusOK)
+		ctx.Writer.Flush()
+
+		emitter := &Emitter[T]{ctx: ctx}
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		if o.heartbeat > 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ticker := time.NewTicker(o.heartbeat)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						if emitter.heartbeat() != nil {
+							return
+						}
+					case <-stop:
+						return
+					case <-ctx.Request.Context().Done():
+						return
+					}
+				}
+			}()
+		}
+
+		res, err := bizFunc(ctx, req, emitter)
+		close(stop)
+		wg.Wait()
+		if err != nil {
+			L.Error("执行业务逻辑失败", logger.String("path", ctx.Request.URL.Path), logger.String("route", ctx.FullPath()), logger.Error(err))
+		}
+		if ctx.Request.Context().Err() != nil {
+			countCode(ctx, streamCanceledCode)
+			emitter.close()
+			return
+		}
+		// 响应头已经发出，errx.Error 只体现在 Result 中
+		var e *errx.Error
+		if errors.As(err, &e) {
+			_, res = ErrorResult(e)
+		}
+		countCode(ctx, strconv.Itoa(res.Code))
+		frame, err := encodeEvent(Event[Result]{Event: StreamResultEvent, Data: res})
+		if err == nil {
+			err = emitter.write(frame)
+		}
+		if err != nil {
+			L.Error("推送结果失败", logger.Error(err))
+		}
+		emitter.close()
+	}
+}
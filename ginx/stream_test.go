@@ -0,0 +1,104 @@
+package ginx
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type streamReq struct {
+	Count int `form:"count"`
+}
+
+type streamProgress struct {
+	Done int `json:"done"`
+}
+
+func TestWrapStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	canceled := make(chan error, 1)
+	engine.GET("/stream", WrapStream(func(ctx *gin.Context, req streamReq, emitter *Emitter[streamProgress]) (Result, error) {
+		for i := 0; i < req.Count; i++ {
+			if err := emitter.SendEvent(Event[streamProgress]{ID: "1", Event: "progress", Data: streamProgress{Done: i}}); err != nil {
+				return Result{}, err
+			}
+		}
+		time.Sleep(30 * time.Millisecond)
+		return Result{Code: 0, Msg: "ok"}, nil
+	}, WithHeartbeat(10*time.Millisecond)))
+	engine.GET("/lines", WrapStream(func(ctx *gin.Context, req streamReq, emitter *Emitter[string]) (Result, error) {
+		// 换行会破坏事件的边界
+		if err := emitter.SendEvent(Event[string]{ID: "1\nevent: forged", Data: "x"}); !errors.Is(err, ErrInvalidEvent) {
+			return Result{}, err
+		}
+		if err := emitter.SendEvent(Event[string]{Event: "progress\r", Data: "x"}); !errors.Is(err, ErrInvalidEvent) {
+			return Result{}, err
+		}
+		return Result{Msg: "ok"}, emitter.Send("line1\nline2\r\nline3\rline4")
+	}))
+	engine.GET("/forever", WrapStream(func(ctx *gin.Context, req streamReq, emitter *Emitter[string]) (Result, error) {
+		for {
+			if err := emitter.Send("tick"); err != nil {
+				canceled <- err
+				return Result{}, err
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}))
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	t.Run("推送事件并以 result 结束", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/stream?count=2")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		content := string(body)
+		assert.Contains(t, content, "id: 1\nevent: progress\ndata: {\"done\":0}\n\n")
+		assert.Contains(t, content, "data: {\"done\":1}\n\n")
+		assert.Contains(t, content, ": ping\n\n")
+		assert.True(t, strings.HasSuffix(content, "event: result\ndata: {\"code\":0,\"msg\":\"ok\",\"data\":null}\n\n"))
+	})
+
+	t.Run("按 CRLF、CR 与 LF 拆分 data", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/lines")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "data: line1\ndata: line2\ndata: line3\ndata: line4\n\n"+
+			"event: result\ndata: {\"code\":0,\"msg\":\"ok\",\"data\":null}\n\n", string(body))
+	})
+
+	t.Run("客户端断开后取消", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/forever", nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		line, err := bufio.NewReader(resp.Body).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "data: tick\n", line)
+		cancel()
+		resp.Body.Close()
+		select {
+		case err = <-canceled:
+			assert.ErrorIs(t, err, context.Canceled)
+		case <-time.After(time.Second):
+			t.Fatal("客户端断开后业务没有退出")
+		}
+	})
+}
@@ -1,22 +1,51 @@
 package ginx
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/to404hanga/pkg404/errx"
 	"github.com/to404hanga/pkg404/logger"
+	"github.com/to404hanga/pkg404/prometheusx"
 )
 
+// ResultCodeKey Wrap 系列方法将 Result.Code 写入 gin.Context 使用的 key，供中间件读取
+const ResultCodeKey = "ginx_result_code"
+
 var (
 	L      logger.Logger = logger.NewNopLogger()
 	vector *prometheus.CounterVec
 )
 
+// InitCounter 将按 Result.Code 统计的计数器注册到默认的 Registerer，未初始化时不计数
 func InitCounter(opt prometheus.CounterOpts) {
-	vector = prometheus.NewCounterVec(opt, []string{"code"})
-	prometheus.MustRegister(vector)
+	InitCounterWithRegisterer(opt, prometheus.DefaultRegisterer)
+}
+
+// InitCounterWithRegisterer 将按 Result.Code 统计的计数器注册到 reg，重复注册时复用已注册的计数器
+func InitCounterWithRegisterer(opt prometheus.CounterOpts, reg prometheus.Registerer) {
+	vector = prometheusx.Register(reg, prometheus.NewCounterVec(opt, []string{"code"}))
+}
+
+func countCode(ctx *gin.Context, code string) {
+	ctx.Set(ResultCodeKey, code)
+	if vector != nil {
+		vector.WithLabelValues(code).Inc()
+	}
+}
+
+// render 业务返回 errx.Error 时以 ErrorResult 的结果响应，否则以 200 返回业务的 Result
+func render(ctx *gin.Context, res Result, err error) {
+	code := http.StatusOK
+	var e *errx.Error
+	if errors.As(err, &e) {
+		code, res = ErrorResult(e)
+	}
+	countCode(ctx, strconv.Itoa(res.Code))
+	ctx.JSON(code, res)
 }
 
 func WrapBodyAndClaims[Req any, Claims any](bizFunc func(ctx *gin.Context, req Req, claims Claims) (Result, error)) gin.HandlerFunc {
@@ -38,11 +67,10 @@ func WrapBodyAndClaims[Req any, Claims any](bizFunc func(ctx *gin.Context, req R
 			return
 		}
 		res, err := bizFunc(ctx, req, claims)
-		vector.WithLabelValues(strconv.Itoa(res.Code)).Inc()
 		if err != nil {
 			L.Error("执行业务逻辑失败", logger.Error(err))
 		}
-		ctx.JSON(http.StatusOK, res)
+		render(ctx, res, err)
 	}
 }
 
@@ -55,11 +83,10 @@ func WrapBody[Req any](bizFunc func(ctx *gin.Context, req Req) (Result, error))
 		}
 		L.Debug("输入参数", logger.Any("req", req))
 		res, err := bizFunc(ctx, req)
-		vector.WithLabelValues(strconv.Itoa(res.Code)).Inc()
 		if err != nil {
 			L.Error("执行业务逻辑失败", logger.Error(err))
 		}
-		ctx.JSON(http.StatusOK, res)
+		render(ctx, res, err)
 	}
 }
 
@@ -76,21 +103,19 @@ func WrapClaims[Claims any](bizFunc func(ctx *gin.Context, claims Claims) (Resul
 			return
 		}
 		res, err := bizFunc(ctx, claims)
-		vector.WithLabelValues(strconv.Itoa(res.Code)).Inc()
 		if err != nil {
 			L.Error("执行业务逻辑失败", logger.Error(err))
 		}
-		ctx.JSON(http.StatusOK, res)
+		render(ctx, res, err)
 	}
 }
 
 func Wrap(bizFunc func(ctx *gin.Context) (Result, error)) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		res, err := bizFunc(ctx)
-		vector.WithLabelValues(strconv.Itoa(res.Code)).Inc()
 		if err != nil {
 			L.Error("执行业务逻辑失败", logger.String("path", ctx.Request.URL.Path), logger.String("route", ctx.FullPath()), logger.Error(err))
 		}
-		ctx.JSON(http.StatusOK, res)
+		render(ctx, res, err)
 	}
 }
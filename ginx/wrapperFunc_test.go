@@ -0,0 +1,78 @@
+package ginx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/to404hanga/pkg404/errx"
+	"google.golang.org/grpc/codes"
+)
+
+func TestWrap_Counter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/code", Wrap(func(ctx *gin.Context) (Result, error) {
+		return Result{Code: 1}, nil
+	}))
+
+	// 未初始化计数器时不 panic
+	old := vector
+	vector = nil
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/code", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// 重复初始化时复用已注册的计数器
+	reg := prometheus.NewRegistry()
+	opt := prometheus.CounterOpts{Name: "biz_code"}
+	InitCounterWithRegisterer(opt, reg)
+	first := vector
+	InitCounterWithRegisterer(opt, reg)
+	assert.Same(t, first, vector)
+
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/code", nil))
+	assert.Equal(t, float64(1), testutil.ToFloat64(vector.WithLabelValues("1")))
+	vector = old
+}
+
+func TestWrap_Error(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/errx", Wrap(func(ctx *gin.Context) (Result, error) {
+		return Result{}, fmt.Errorf("查询用户: %w", errx.New(codes.NotFound, "USER_NOT_FOUND", "用户不存在").
+			WithMetadata(map[string]string{"id": "1"}))
+	}))
+	engine.GET("/plain", Wrap(func(ctx *gin.Context) (Result, error) {
+		return Result{Code: 5, Msg: "系统错误"}, errors.New("boom")
+	}))
+
+	// errx.Error 以对应的 HTTP 状态码返回
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/errx", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.JSONEq(t, `{"code":100005,"msg":"用户不存在","data":{"status":"NOT_FOUND","reason":"USER_NOT_FOUND","metadata":{"id":"1"}}}`, recorder.Body.String())
+
+	// 其他错误仍然返回业务的 Result
+	recorder = httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/plain", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"code":5,"msg":"系统错误","data":null}`, recorder.Body.String())
+}
+
+func TestErrorResult(t *testing.T) {
+	code, res := ErrorResult(errx.New(codes.DeadlineExceeded, "TIMEOUT", "超时"))
+	assert.Equal(t, http.StatusGatewayTimeout, code)
+	assert.Equal(t, ErrorCodeBase+int(codes.DeadlineExceeded), res.Code)
+	assert.Equal(t, ErrorData{Status: "DEADLINE_EXCEEDED", Reason: "TIMEOUT"}, res.Data)
+
+	// 业务错误码与 gRPC 状态码数值相同也不会冲突
+	assert.NotEqual(t, int(codes.NotFound), ErrorCode(codes.NotFound))
+	assert.Equal(t, "OK", statusName(codes.OK))
+}
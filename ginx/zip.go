@@ -0,0 +1,48 @@
+package ginx
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/to404hanga/pkg404/gotools/zip"
+	"github.com/to404hanga/pkg404/logger"
+)
+
+// ZipDownload 将 srcs 中的文件或目录压缩后直接写入响应，不落盘
+//
+// filename 为下载的文件名，cfg 不为空时使用 AES 加密，客户端断开后停止压缩。
+// 响应头发出后无法再修改状态码，压缩失败时只能中断响应并记录日志
+func ZipDownload(ctx *gin.Context, filename string, srcs []string, cfg ...zip.EncryptConfig) error {
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	ctx.Status(http.StatusOK)
+	err := zip.ZipFilesWriter(ctx.Request.Context(), ctx.Writer, srcs, cfg...)
+	if err != nil {
+		L.Error("压缩下载失败", logger.String("path", ctx.Request.URL.Path), logger.String("filename", filename), logger.Error(err))
+	}
+	return err
+}
+
+// WrapZip 业务返回需要下载的文件名与文件列表，由 ZipDownload 写入响应
+//
+// 业务返回 error 时以 JSON 返回 Result
+func WrapZip[Req any](bizFunc func(ctx *gin.Context, req Req) (filename string, srcs []string, res Result, err error), cfg ...zip.EncryptConfig) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var req Req
+		if err := ctx.Bind(&req); err != nil {
+			L.Error("输入错误", logger.Error(err))
+			return
+		}
+		L.Debug("输入参数", logger.Any("req", req))
+		filename, srcs, res, err := bizFunc(ctx, req)
+		if err != nil {
+			L.Error("执行业务逻辑失败", logger.Error(err))
+			render(ctx, res, err)
+			return
+		}
+		countCode(ctx, strconv.Itoa(res.Code))
+		_ = ZipDownload(ctx, filename, srcs, cfg...)
+	}
+}
@@ -0,0 +1,67 @@
+package ginx
+
+import (
+	stdzip "archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/gotools/zip"
+)
+
+func TestZipDownload(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "docs", "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", "a.txt"), []byte("aaa"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", "sub", "b.txt"), []byte("bbb"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "c.txt"), []byte("ccc"), 0644))
+	srcs := []string{filepath.Join(dir, "docs"), filepath.Join(dir, "c.txt")}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/plain", func(ctx *gin.Context) {
+		_ = ZipDownload(ctx, "文件.zip", srcs)
+	})
+	engine.GET("/encrypted", func(ctx *gin.Context) {
+		_ = ZipDownload(ctx, "files.zip", srcs, zip.EncryptConfig{Password: "123456", Enc: zip.AES128})
+	})
+
+	t.Run("不加密", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/plain", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "application/zip", recorder.Header().Get("Content-Type"))
+		assert.Contains(t, recorder.Header().Get("Content-Disposition"), "attachment")
+
+		body := recorder.Body.Bytes()
+		reader, err := stdzip.NewReader(bytes.NewReader(body), int64(len(body)))
+		require.NoError(t, err)
+		names := make([]string, 0, len(reader.File))
+		for _, f := range reader.File {
+			names = append(names, f.Name)
+		}
+		sort.Strings(names)
+		assert.Equal(t, []string{"c.txt", "docs/", "docs/a.txt", "docs/sub/", "docs/sub/b.txt"}, names)
+	})
+
+	t.Run("加密", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/encrypted", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		out := t.TempDir()
+		dst := filepath.Join(out, "files.zip")
+		require.NoError(t, os.WriteFile(dst, recorder.Body.Bytes(), 0644))
+		require.NoError(t, zip.UnzipLib(dst, filepath.Join(out, "unzip"), "123456"))
+		data, err := os.ReadFile(filepath.Join(out, "unzip", "docs", "sub", "b.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "bbb", string(data))
+	})
+}
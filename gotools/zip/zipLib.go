@@ -1,10 +1,12 @@
 package zip
 
 import (
+	"context"
 	"io"
-	"log"
 	"os"
+	pathpkg "path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/itnotebooks/zip"
@@ -20,7 +22,9 @@ type EncryptConfig struct {
 // 如果 cfg 为空，则不加密
 //
 // 加密方式: Standard, AES128, AES192, AES256(默认)
-func ZipLib(dst, src string, cfg ...EncryptConfig) error {
+//
+// 压缩目录时压缩包内包含根目录项 "./"，压缩单个文件时压缩包内的文件名为该文件的文件名
+func ZipLib(dst, src string, cfg ...EncryptConfig) (err error) {
 	// 创建压缩文件
 	zfile, err := os.Create(dst)
 	if err != nil {
@@ -33,37 +37,93 @@ func ZipLib(dst, src string, cfg ...EncryptConfig) error {
 		}
 	}()
 
-	// 创建zip写入器
-	zFileWriter := zip.NewWriter(zfile)
+	return ZipWriter(context.Background(), zfile, src, cfg...)
+}
+
+// ZipWriter 递归压缩文件或目录并写入 w，支持AES加密，ctx 取消后停止压缩并返回 ctx 的错误
+//
+// 压缩包内的路径相对于 src，cfg 与压缩包内路径的规则与 ZipLib 相同
+func ZipWriter(ctx context.Context, w io.Writer, src string, cfg ...EncryptConfig) (err error) {
+	zw := zip.NewWriter(&ctxWriter{ctx: ctx, w: w})
+	defer func() {
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return addPath(ctx, zw, src, "", cfg)
+}
+
+// ZipFilesWriter 将多个文件或目录压缩并写入 w，每个路径以其文件名作为压缩包内的顶层名称
+//
+// 文件名重复时依次改名为 name(1).ext、name(2).ext，ctx 与 cfg 的含义与 ZipWriter 相同
+func ZipFilesWriter(ctx context.Context, w io.Writer, srcs []string, cfg ...EncryptConfig) (err error) {
+	zw := zip.NewWriter(&ctxWriter{ctx: ctx, w: w})
 	defer func() {
-		if err := zFileWriter.Close(); err != nil {
-			log.Fatalln(err)
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
 		}
 	}()
+	used := make(map[string]struct{}, len(srcs))
+	for _, src := range srcs {
+		src = filepath.Clean(src)
+		if err = addPath(ctx, zw, src, uniqueName(used, filepath.Base(src)), cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uniqueName 返回 used 中未出现过的名称并记录
+func uniqueName(used map[string]struct{}, name string) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		if _, ok := used[name]; !ok {
+			used[name] = struct{}{}
+			return name
+		}
+		name = stem + "(" + strconv.Itoa(i) + ")" + ext
+	}
+}
 
+// addPath 将 src 下的所有文件写入 zw，压缩包内的路径为 root 拼接相对于 src 的路径
+//
+// root 为空时目录 src 本身写为 "./"，文件 src 本身以文件名写入
+func addPath(ctx context.Context, zw *zip.Writer, src, root string, cfg []EncryptConfig) error {
 	// 遍历源路径下的所有文件
 	return filepath.Walk(src, func(path string, fi os.FileInfo, errBack error) error {
 		if errBack != nil {
 			return errBack
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
 		// 跳过zip文件
 		if strings.HasSuffix(path, ".zip") {
 			return nil
 		}
 
-		// 创建文件头
-		header, err := zip.FileInfoHeader(fi)
+		// 设置相对路径
+		relPath, err := filepath.Rel(src, path)
 		if err != nil {
 			return err
 		}
+		name := filepath.ToSlash(relPath)
+		switch {
+		case root != "":
+			name = pathpkg.Join(root, name)
+		case relPath == "." && !fi.IsDir():
+			// 单个文件压缩时相对路径为 .，使用文件名
+			name = fi.Name()
+		}
 
-		// 设置相对路径
-		relPath, err := filepath.Rel(src, path)
+		// 创建文件头
+		header, err := zip.FileInfoHeader(fi)
 		if err != nil {
 			return err
 		}
-		header.Name = relPath
+		header.Name = name
 
 		// 目录处理
 		if fi.IsDir() {
@@ -76,9 +136,9 @@ func ZipLib(dst, src string, cfg ...EncryptConfig) error {
 		var fh io.Writer
 		if len(cfg) != 0 {
 			encryption := getEncryption(cfg[0].Enc)
-			fh, err = zFileWriter.Encrypt(header, cfg[0].Password, encryption)
+			fh, err = zw.Encrypt(header, cfg[0].Password, encryption)
 		} else {
-			fh, err = zFileWriter.CreateHeader(header)
+			fh, err = zw.CreateHeader(header)
 		}
 		if err != nil {
 			return err
@@ -101,6 +161,19 @@ func ZipLib(dst, src string, cfg ...EncryptConfig) error {
 	})
 }
 
+// ctxWriter 在 ctx 取消后拒绝写入
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w *ctxWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
 // getEncryption 根据字符串返回对应的加密方式
 func getEncryption(enc string) zip.EncryptionMethod {
 	switch enc {
@@ -0,0 +1,132 @@
+package zip
+
+import (
+	stdzip "archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// entries 读取压缩包内的文件名与未加密文件的内容
+func entries(t *testing.T, data []byte) map[string]string {
+	reader, err := stdzip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	res := make(map[string]string, len(reader.File))
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		res[f.Name] = string(content)
+	}
+	return res
+}
+
+func names(m map[string]string) []string {
+	res := make([]string, 0, len(m))
+	for name := range m {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func writeFile(t *testing.T, path, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func TestZipLib(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "src", "a.txt"), "aaa")
+	writeFile(t, filepath.Join(dir, "src", "sub", "b.txt"), "bbb")
+	writeFile(t, filepath.Join(dir, "src", "skip.zip"), "zip")
+
+	t.Run("目录", func(t *testing.T) {
+		dst := filepath.Join(dir, "dir.zip")
+		require.NoError(t, ZipLib(dst, filepath.Join(dir, "src")))
+		data, err := os.ReadFile(dst)
+		require.NoError(t, err)
+		got := entries(t, data)
+		assert.Equal(t, []string{"./", "a.txt", "sub/", "sub/b.txt"}, names(got))
+		assert.Equal(t, "bbb", got["sub/b.txt"])
+	})
+
+	t.Run("单个文件", func(t *testing.T) {
+		dst := filepath.Join(dir, "file.zip")
+		require.NoError(t, ZipLib(dst, filepath.Join(dir, "src", "a.txt")))
+		data, err := os.ReadFile(dst)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"a.txt": "aaa"}, entries(t, data))
+	})
+
+	t.Run("加密后解压", func(t *testing.T) {
+		dst := filepath.Join(dir, "encrypted.zip")
+		require.NoError(t, ZipLib(dst, filepath.Join(dir, "src"), EncryptConfig{Password: "123456", Enc: Default}))
+		out := filepath.Join(dir, "unzip")
+		require.NoError(t, UnzipLib(dst, out, "123456"))
+		content, err := os.ReadFile(filepath.Join(out, "sub", "b.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "bbb", string(content))
+	})
+
+	t.Run("源路径不存在时删除压缩文件", func(t *testing.T) {
+		dst := filepath.Join(dir, "missing.zip")
+		assert.Error(t, ZipLib(dst, filepath.Join(dir, "missing")))
+		_, err := os.Stat(dst)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestZipWriter(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.txt"), "aaa")
+
+	t.Run("写入 io.Writer", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, ZipWriter(context.Background(), &buf, dir))
+		assert.Equal(t, map[string]string{"./": "", "a.txt": "aaa"}, entries(t, buf.Bytes()))
+	})
+
+	t.Run("ctx 取消", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		var buf bytes.Buffer
+		assert.ErrorIs(t, ZipWriter(ctx, &buf, dir), context.Canceled)
+	})
+}
+
+func TestZipFilesWriter(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "x", "docs", "a.txt"), "x-a")
+	writeFile(t, filepath.Join(dir, "y", "docs", "a.txt"), "y-a")
+	writeFile(t, filepath.Join(dir, "x", "report.txt"), "x-report")
+	writeFile(t, filepath.Join(dir, "y", "report.txt"), "y-report")
+	writeFile(t, filepath.Join(dir, "z", "report.txt"), "z-report")
+
+	var buf bytes.Buffer
+	require.NoError(t, ZipFilesWriter(context.Background(), &buf, []string{
+		filepath.Join(dir, "x", "docs"),
+		filepath.Join(dir, "y", "docs") + string(filepath.Separator),
+		filepath.Join(dir, "x", "report.txt"),
+		filepath.Join(dir, "y", "report.txt"),
+		filepath.Join(dir, "z", "report.txt"),
+	}))
+	assert.Equal(t, map[string]string{
+		"docs/":         "",
+		"docs/a.txt":    "x-a",
+		"docs(1)/":      "",
+		"docs(1)/a.txt": "y-a",
+		"report.txt":    "x-report",
+		"report(1).txt": "y-report",
+		"report(2).txt": "z-report",
+	}, entries(t, buf.Bytes()))
+}
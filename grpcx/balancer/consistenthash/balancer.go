@@ -0,0 +1,115 @@
+package consistenthash
+
+import (
+	"context"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/to404hanga/pkg404/grpcx/resolver"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	Name = "consistent_hash"
+	// MetadataKey 请求元数据中作为哈希键的字段，context 中没有哈希键时使用
+	MetadataKey = "x-hash-key"
+	// DefaultReplicas 权重为 1 的实例在哈希环上的虚拟节点数
+	DefaultReplicas = 100
+	// MaxWeight 计算虚拟节点数时权重的上限，避免错误的权重使哈希环占用过多内存，
+	// 超过的权重按 MaxWeight 计算，即每个实例最多 DefaultReplicas * MaxWeight 个虚拟节点
+	MaxWeight = 100
+)
+
+type hashKey struct{}
+
+// WithHashKey 指定本次请求的哈希键，相同哈希键的请求会落到同一个实例上，优先级高于请求元数据
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// HashKeyFromContext 依次从 context 与发出请求的元数据中读取哈希键
+func HashKeyFromContext(ctx context.Context) (string, bool) {
+	if key, ok := ctx.Value(hashKey{}).(string); ok && key != "" {
+		return key, true
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if vals := md.Get(MetadataKey); len(vals) > 0 && vals[0] != "" {
+			return vals[0], true
+		}
+	}
+	return "", false
+}
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(Name, &PickerBuilder{}, base.Config{
+		HealthCheck: true,
+	}))
+}
+
+// PickerBuilder 根据就绪的实例构建哈希环，实例元数据中的 weight 决定虚拟节点的倍数
+type PickerBuilder struct{}
+
+func (p *PickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	picker := &Picker{
+		nodes: make([]node, 0, len(info.ReadySCs)*DefaultReplicas),
+		conns: make([]balancer.SubConn, 0, len(info.ReadySCs)),
+	}
+	for sc, sci := range info.ReadySCs {
+		picker.conns = append(picker.conns, sc)
+		replicas := DefaultReplicas * weightOf(sci)
+		for i := 0; i < replicas; i++ {
+			picker.nodes = append(picker.nodes, node{
+				hash: xxhash.Sum64String(sci.Address.Addr + "#" + strconv.Itoa(i)),
+				addr: sci.Address.Addr,
+				sc:   sc,
+			})
+		}
+	}
+	// 哈希值相同时按地址排序，保证环的结构与 map 的遍历顺序无关
+	sort.Slice(picker.nodes, func(i, j int) bool {
+		if picker.nodes[i].hash != picker.nodes[j].hash {
+			return picker.nodes[i].hash < picker.nodes[j].hash
+		}
+		return picker.nodes[i].addr < picker.nodes[j].addr
+	})
+	return picker
+}
+
+// weightOf 与其他负载均衡器一样通过 resolver.Weight 读取权重，超过 MaxWeight 的按 MaxWeight 计算
+func weightOf(sci base.SubConnInfo) int {
+	return min(resolver.Weight(sci.Address), MaxWeight)
+}
+
+type node struct {
+	hash uint64
+	addr string
+	sc   balancer.SubConn
+}
+
+type Picker struct {
+	nodes []node
+	conns []balancer.SubConn
+}
+
+// Pick 选择哈希环上顺时针方向第一个虚拟节点对应的实例，没有哈希键时随机选择
+func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	key, ok := HashKeyFromContext(info.Ctx)
+	if !ok {
+		return balancer.PickResult{SubConn: p.conns[rand.IntN(len(p.conns))]}, nil
+	}
+	hash := xxhash.Sum64String(key)
+	idx := sort.Search(len(p.nodes), func(i int) bool {
+		return p.nodes[i].hash >= hash
+	})
+	if idx == len(p.nodes) {
+		idx = 0
+	}
+	return balancer.PickResult{SubConn: p.nodes[idx].sc}, nil
+}
@@ -0,0 +1,115 @@
+package consistenthash
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/grpcx/resolver"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	gresolver "google.golang.org/grpc/resolver"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+func buildInfo(addrs ...string) base.PickerBuildInfo {
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for _, addr := range addrs {
+		info.ReadySCs[&fakeSubConn{addr: addr}] = base.SubConnInfo{Address: gresolver.Address{Addr: addr}}
+	}
+	return info
+}
+
+func pick(t *testing.T, p balancer.Picker, ctx context.Context) string {
+	pr, err := p.Pick(balancer.PickInfo{Ctx: ctx})
+	require.NoError(t, err)
+	return pr.SubConn.(*fakeSubConn).addr
+}
+
+func TestPicker_Sticky(t *testing.T) {
+	p := (&PickerBuilder{}).Build(buildInfo("a", "b", "c"))
+	for i := 0; i < 100; i++ {
+		key := "user-" + strconv.Itoa(i)
+		first := pick(t, p, WithHashKey(context.Background(), key))
+		// 相同的哈希键总是落到同一个实例，无论来自 context 还是元数据
+		assert.Equal(t, first, pick(t, p, WithHashKey(context.Background(), key)))
+		assert.Equal(t, first, pick(t, p, metadata.AppendToOutgoingContext(context.Background(), MetadataKey, key)))
+		// 重新构建不影响映射关系
+		assert.Equal(t, first, pick(t, (&PickerBuilder{}).Build(buildInfo("c", "b", "a")), WithHashKey(context.Background(), key)))
+	}
+}
+
+func TestPicker_Distribution(t *testing.T) {
+	p := (&PickerBuilder{}).Build(buildInfo("a", "b", "c"))
+	served := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		served[pick(t, p, WithHashKey(context.Background(), "user-"+strconv.Itoa(i)))]++
+	}
+	for _, addr := range []string{"a", "b", "c"} {
+		assert.InDelta(t, 1000, served[addr], 200, addr)
+	}
+
+	// 没有哈希键时随机选择
+	served = make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		served[pick(t, p, context.Background())]++
+	}
+	for _, addr := range []string{"a", "b", "c"} {
+		assert.InDelta(t, 1000, served[addr], 200, addr)
+	}
+}
+
+func TestPicker_Membership(t *testing.T) {
+	before := (&PickerBuilder{}).Build(buildInfo("a", "b", "c"))
+	after := (&PickerBuilder{}).Build(buildInfo("a", "b", "c", "d"))
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		ctx := WithHashKey(context.Background(), "user-"+strconv.Itoa(i))
+		from, to := pick(t, before, ctx), pick(t, after, ctx)
+		if from != to {
+			// 新增实例只会接管其他实例的部分哈希键
+			assert.Equal(t, "d", to)
+			moved++
+		}
+	}
+	assert.InDelta(t, 750, moved, 200)
+
+	_, err := (&PickerBuilder{}).Build(base.PickerBuildInfo{}).Pick(balancer.PickInfo{Ctx: context.Background()})
+	assert.ErrorIs(t, err, balancer.ErrNoSubConnAvailable)
+}
+
+func TestPickerBuilder_Weight(t *testing.T) {
+	info := buildInfo("a", "b")
+	for sc, sci := range info.ReadySCs {
+		if sc.(*fakeSubConn).addr == "a" {
+			sci.Address = resolver.SetMetadata(sci.Address, resolver.Metadata{"weight": float64(30)})
+			info.ReadySCs[sc] = sci
+		}
+	}
+	// 没有设置权重的 b 与其他负载均衡器一样使用 resolver.DefaultWeight
+	p := (&PickerBuilder{}).Build(info)
+	served := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		served[pick(t, p, WithHashKey(context.Background(), "user-"+strconv.Itoa(i)))]++
+	}
+	assert.InDelta(t, 3000, served["a"], 300)
+	assert.InDelta(t, 1000, served["b"], 300)
+	assert.Len(t, p.(*Picker).nodes, DefaultReplicas*(30+resolver.DefaultWeight))
+}
+
+func TestPickerBuilder_MaxWeight(t *testing.T) {
+	info := buildInfo("a", "b")
+	for sc, sci := range info.ReadySCs {
+		sci.Address = resolver.SetMetadata(sci.Address, resolver.Metadata{"weight": float64(1e12)})
+		info.ReadySCs[sc] = sci
+	}
+	p := (&PickerBuilder{}).Build(info).(*Picker)
+	assert.Len(t, p.nodes, 2*DefaultReplicas*MaxWeight)
+}
@@ -0,0 +1,106 @@
+package leastrequest
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+const Name = "least_request"
+
+type builder struct{}
+
+// Build 每个 ClientConn 使用独立的 PickerBuilder，避免不同连接之间共享请求计数
+func (builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	return base.NewBalancerBuilder(Name, NewPickerBuilder(), base.Config{
+		HealthCheck: true,
+	}).Build(cc, opts)
+}
+
+func (builder) Name() string {
+	return Name
+}
+
+func init() {
+	balancer.Register(builder{})
+}
+
+// PickerBuilder 按地址保留进行中的请求数，重建 Picker 时不会丢失尚未完成的请求
+type PickerBuilder struct {
+	lock     sync.Mutex
+	inflight map[string]*atomic.Int64
+}
+
+func NewPickerBuilder() *PickerBuilder {
+	return &PickerBuilder{
+		inflight: make(map[string]*atomic.Int64),
+	}
+}
+
+func (p *PickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	conns := make([]*requestConn, 0, len(info.ReadySCs))
+	alive := make(map[string]struct{}, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		addr := sci.Address.Addr
+		alive[addr] = struct{}{}
+		inflight, ok := p.inflight[addr]
+		if !ok {
+			inflight = new(atomic.Int64)
+			p.inflight[addr] = inflight
+		}
+		conns = append(conns, &requestConn{SubConn: sc, addr: addr, inflight: inflight})
+	}
+	for addr := range p.inflight {
+		if _, ok := alive[addr]; !ok {
+			delete(p.inflight, addr)
+		}
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].addr < conns[j].addr
+	})
+	return &Picker{conns: conns}
+}
+
+type Picker struct {
+	conns []*requestConn
+	// next 请求数相同时轮询的起点，避免总是选中第一个实例
+	next atomic.Uint32
+}
+
+// Pick 选择进行中请求数最少的实例
+func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if len(p.conns) == 0 {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	start := int(p.next.Add(1)-1) % len(p.conns)
+	res := p.conns[start]
+	min := res.inflight.Load()
+	for i := 1; i < len(p.conns); i++ {
+		c := p.conns[(start+i)%len(p.conns)]
+		if n := c.inflight.Load(); n < min {
+			res, min = c, n
+		}
+	}
+	res.inflight.Add(1)
+	return balancer.PickResult{
+		SubConn: res.SubConn,
+		Done: func(di balancer.DoneInfo) {
+			res.inflight.Add(-1)
+		},
+	}, nil
+}
+
+type requestConn struct {
+	balancer.SubConn
+	addr string
+	// inflight 同一地址的所有 Picker 共享
+	inflight *atomic.Int64
+}
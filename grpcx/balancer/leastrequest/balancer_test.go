@@ -0,0 +1,100 @@
+package leastrequest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	gresolver "google.golang.org/grpc/resolver"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+func buildInfo(addrs ...string) base.PickerBuildInfo {
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for _, addr := range addrs {
+		info.ReadySCs[&fakeSubConn{addr: addr}] = base.SubConnInfo{Address: gresolver.Address{Addr: addr}}
+	}
+	return info
+}
+
+func pick(t *testing.T, p balancer.Picker) (string, func()) {
+	pr, err := p.Pick(balancer.PickInfo{})
+	require.NoError(t, err)
+	return pr.SubConn.(*fakeSubConn).addr, func() {
+		pr.Done(balancer.DoneInfo{})
+	}
+}
+
+func TestPicker_Distribution(t *testing.T) {
+	p := NewPickerBuilder().Build(buildInfo("a", "b", "c"))
+
+	// 请求立即完成时均匀分布
+	served := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		addr, done := pick(t, p)
+		done()
+		served[addr]++
+	}
+	assert.Equal(t, map[string]int{"a": 10, "b": 10, "c": 10}, served)
+
+	// 每个实例上各有两个请求，只完成 b 与 c 上的请求，后续请求优先落到 b 与 c
+	var pending, finished []func()
+	for i := 0; i < 6; i++ {
+		addr, done := pick(t, p)
+		if addr == "a" {
+			pending = append(pending, done)
+			continue
+		}
+		finished = append(finished, done)
+	}
+	require.Len(t, pending, 2)
+	for _, done := range finished {
+		done()
+	}
+	served = make(map[string]int)
+	var dones []func()
+	for i := 0; i < 4; i++ {
+		addr, done := pick(t, p)
+		dones = append(dones, done)
+		served[addr]++
+	}
+	assert.Equal(t, map[string]int{"b": 2, "c": 2}, served)
+	for _, done := range append(dones, pending...) {
+		done()
+	}
+}
+
+func TestPickerBuilder_Membership(t *testing.T) {
+	pb := NewPickerBuilder()
+
+	_, err := pb.Build(base.PickerBuildInfo{}).Pick(balancer.PickInfo{})
+	assert.ErrorIs(t, err, balancer.ErrNoSubConnAvailable)
+
+	p := pb.Build(buildInfo("a", "b"))
+	addr, done := pick(t, p)
+
+	// 重建后保留进行中的请求数
+	p = pb.Build(buildInfo("a", "b", "c"))
+	assert.Equal(t, int64(1), pb.inflight[addr].Load())
+	served := make(map[string]int)
+	for i := 0; i < 2; i++ {
+		got, _ := pick(t, p)
+		served[got]++
+	}
+	assert.Zero(t, served[addr])
+	done()
+	assert.Zero(t, pb.inflight[addr].Load())
+
+	// 移除的实例不再被选中，其状态被清理
+	p = pb.Build(buildInfo("c"))
+	got, _ := pick(t, p)
+	assert.Equal(t, "c", got)
+	assert.NotContains(t, pb.inflight, "a")
+	assert.NotContains(t, pb.inflight, "b")
+}
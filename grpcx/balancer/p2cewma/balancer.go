@@ -0,0 +1,180 @@
+package p2cewma
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	Name = "p2c_ewma"
+	// decayTime EWMA 的衰减时间，距离上一次更新越久，旧的延迟占比越小
+	decayTime = 10 * time.Second
+	// forcePick 实例超过这个时间没有被选中时强制选中一次，让慢实例有机会恢复
+	forcePick = 3 * time.Second
+	// errorPenalty 失败的请求按这个延迟计入，避免快速失败的实例吸引更多流量
+	errorPenalty = time.Second
+)
+
+type builder struct{}
+
+// Build 每个 ClientConn 使用独立的 PickerBuilder，避免不同连接之间共享延迟统计
+func (builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	return base.NewBalancerBuilder(Name, NewPickerBuilder(), base.Config{
+		HealthCheck: true,
+	}).Build(cc, opts)
+}
+
+func (builder) Name() string {
+	return Name
+}
+
+func init() {
+	balancer.Register(builder{})
+}
+
+// PickerBuilder 按地址保留延迟统计，重建 Picker 时不会丢失历史数据
+type PickerBuilder struct {
+	lock  sync.Mutex
+	stats map[string]*ewmaStats
+}
+
+func NewPickerBuilder() *PickerBuilder {
+	return &PickerBuilder{
+		stats: make(map[string]*ewmaStats),
+	}
+}
+
+func (p *PickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now().UnixNano()
+	conns := make([]*ewmaConn, 0, len(info.ReadySCs))
+	alive := make(map[string]struct{}, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		addr := sci.Address.Addr
+		alive[addr] = struct{}{}
+		st, ok := p.stats[addr]
+		if !ok {
+			st = &ewmaStats{stamp: now}
+			st.picked.Store(now)
+			p.stats[addr] = st
+		}
+		conns = append(conns, &ewmaConn{SubConn: sc, addr: addr, ewmaStats: st})
+	}
+	for addr := range p.stats {
+		if _, ok := alive[addr]; !ok {
+			delete(p.stats, addr)
+		}
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].addr < conns[j].addr
+	})
+	return &Picker{conns: conns}
+}
+
+type Picker struct {
+	conns []*ewmaConn
+}
+
+// Pick 随机选出两个实例，选择 EWMA 延迟与进行中请求数乘积更小的一个
+func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	switch len(p.conns) {
+	case 0:
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	case 1:
+		return p.pick(p.conns[0]), nil
+	}
+	a := rand.IntN(len(p.conns))
+	b := rand.IntN(len(p.conns) - 1)
+	if b >= a {
+		b++
+	}
+	res, other := p.conns[a], p.conns[b]
+	if other.load() < res.load() {
+		res, other = other, res
+	}
+	if time.Now().UnixNano()-other.picked.Load() > int64(forcePick) {
+		res = other
+	}
+	return p.pick(res), nil
+}
+
+func (p *Picker) pick(c *ewmaConn) balancer.PickResult {
+	start := time.Now()
+	c.picked.Store(start.UnixNano())
+	c.inflight.Add(1)
+	return balancer.PickResult{
+		SubConn: c.SubConn,
+		Done: func(di balancer.DoneInfo) {
+			c.inflight.Add(-1)
+			c.observe(start, di.Err)
+		},
+	}
+}
+
+type ewmaConn struct {
+	balancer.SubConn
+	addr string
+	// ewmaStats 同一地址的所有 Picker 共享
+	*ewmaStats
+}
+
+type ewmaStats struct {
+	inflight atomic.Int64
+	// picked 上一次被选中的时间
+	picked atomic.Int64
+
+	lock sync.Mutex
+	// lag 以纳秒为单位的 EWMA 延迟
+	lag float64
+	// stamp 上一次更新 lag 的时间
+	stamp int64
+}
+
+func (c *ewmaStats) load() float64 {
+	c.lock.Lock()
+	lag := c.lag
+	c.lock.Unlock()
+	// 新实例还没有延迟数据，按 1 纳秒计算让它尽快获得流量
+	return math.Max(lag, 1) * float64(c.inflight.Load()+1)
+}
+
+// observe 用本次请求的延迟更新 EWMA，主动取消的请求不计入
+func (c *ewmaStats) observe(start time.Time, err error) {
+	if errors.Is(err, context.Canceled) || status.Code(err) == codes.Canceled {
+		return
+	}
+	now := time.Now()
+	rtt := float64(now.Sub(start))
+	if err != nil {
+		rtt = math.Max(rtt, float64(errorPenalty))
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	td := now.UnixNano() - c.stamp
+	if td < 0 {
+		td = 0
+	}
+	if c.lag == 0 {
+		// 第一次统计直接使用本次的延迟
+		c.lag = rtt
+	} else {
+		w := math.Exp(-float64(td) / float64(decayTime))
+		c.lag = c.lag*w + rtt*(1-w)
+	}
+	c.stamp = now.UnixNano()
+}
@@ -0,0 +1,166 @@
+package p2cewma
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	gresolver "google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+func buildInfo(addrs ...string) base.PickerBuildInfo {
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for _, addr := range addrs {
+		info.ReadySCs[&fakeSubConn{addr: addr}] = base.SubConnInfo{Address: gresolver.Address{Addr: addr}}
+	}
+	return info
+}
+
+func pick(t *testing.T, p balancer.Picker) (string, func()) {
+	pr, err := p.Pick(balancer.PickInfo{})
+	require.NoError(t, err)
+	return pr.SubConn.(*fakeSubConn).addr, func() {
+		pr.Done(balancer.DoneInfo{})
+	}
+}
+
+// newPicker 构建 Picker 并为每个实例设置初始的延迟
+func newPicker(lags map[string]time.Duration) (*PickerBuilder, balancer.Picker) {
+	addrs := make([]string, 0, len(lags))
+	for addr := range lags {
+		addrs = append(addrs, addr)
+	}
+	pb := NewPickerBuilder()
+	p := pb.Build(buildInfo(addrs...))
+	for addr, lag := range lags {
+		pb.stats[addr].lag = float64(lag)
+	}
+	return pb, p
+}
+
+func TestPicker_Distribution(t *testing.T) {
+	t.Run("延迟相同时均匀分布", func(t *testing.T) {
+		_, p := newPicker(map[string]time.Duration{
+			"a": time.Millisecond, "b": time.Millisecond, "c": time.Millisecond, "d": time.Millisecond,
+		})
+		served := make(map[string]int)
+		var dones []func()
+		for i := 0; i < 4000; i++ {
+			addr, done := pick(t, p)
+			dones = append(dones, done)
+			served[addr]++
+		}
+		// 请求未完成时延迟不变，由进行中的请求数决定分布
+		for _, addr := range []string{"a", "b", "c", "d"} {
+			assert.InDelta(t, 1000, served[addr], 50, addr)
+		}
+		for _, done := range dones {
+			done()
+		}
+	})
+
+	t.Run("慢实例不会被选中", func(t *testing.T) {
+		_, p := newPicker(map[string]time.Duration{
+			"a": time.Millisecond, "b": time.Millisecond, "c": time.Millisecond, "d": 100 * time.Millisecond,
+		})
+		served := make(map[string]int)
+		for i := 0; i < 3000; i++ {
+			addr, done := pick(t, p)
+			done()
+			served[addr]++
+		}
+		assert.Zero(t, served["d"])
+		assert.Equal(t, 3000, served["a"]+served["b"]+served["c"])
+	})
+
+	t.Run("进行中的请求越多越不容易被选中", func(t *testing.T) {
+		_, p := newPicker(map[string]time.Duration{"a": time.Millisecond, "b": time.Millisecond})
+		served := make(map[string]int)
+		var dones []func()
+		for i := 0; i < 100; i++ {
+			addr, done := pick(t, p)
+			dones = append(dones, done)
+			served[addr]++
+		}
+		// 只有两个实例时每次都会比较两者，进行中的请求数最多相差 1
+		assert.InDelta(t, served["a"], served["b"], 1)
+		for _, done := range dones {
+			done()
+		}
+	})
+}
+
+func TestPicker_ForcePick(t *testing.T) {
+	pb, p := newPicker(map[string]time.Duration{
+		"a": time.Millisecond, "b": time.Millisecond, "c": time.Second,
+	})
+	pb.stats["c"].picked.Store(time.Now().Add(-2 * forcePick).UnixNano())
+	served := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		addr, done := pick(t, p)
+		done()
+		served[addr]++
+	}
+	// 长时间没有被选中的慢实例只会被强制选中一次
+	assert.Equal(t, 1, served["c"])
+}
+
+func TestEwmaStats_Observe(t *testing.T) {
+	c := &ewmaStats{stamp: time.Now().UnixNano()}
+	start := time.Now().Add(-10 * time.Millisecond)
+	c.observe(start, nil)
+	// 第一次统计直接使用本次的延迟
+	assert.InDelta(t, float64(10*time.Millisecond), c.lag, float64(5*time.Millisecond))
+
+	before := c.lag
+	c.observe(time.Now(), status.Error(codes.Canceled, ""))
+	c.observe(time.Now(), context.Canceled)
+	assert.Equal(t, before, c.lag)
+
+	// 失败按 errorPenalty 计入，延迟上升
+	c.stamp = time.Now().Add(-decayTime).UnixNano()
+	c.observe(time.Now(), status.Error(codes.Unavailable, ""))
+	assert.Greater(t, c.lag, float64(errorPenalty)/2)
+
+	// 成功的快速请求让延迟逐渐下降
+	before = c.lag
+	c.stamp = time.Now().Add(-decayTime).UnixNano()
+	c.observe(time.Now(), nil)
+	assert.Less(t, c.lag, before)
+}
+
+func TestPickerBuilder_Membership(t *testing.T) {
+	pb := NewPickerBuilder()
+
+	_, err := pb.Build(base.PickerBuildInfo{}).Pick(balancer.PickInfo{})
+	assert.ErrorIs(t, err, balancer.ErrNoSubConnAvailable)
+
+	p := pb.Build(buildInfo("a"))
+	addr, done := pick(t, p)
+	assert.Equal(t, "a", addr)
+	done()
+	lag := pb.stats["a"].lag
+	assert.Positive(t, lag)
+
+	// 重建后保留延迟统计
+	pb.Build(buildInfo("a", "b"))
+	assert.Equal(t, lag, pb.stats["a"].lag)
+	assert.Zero(t, pb.stats["b"].lag)
+
+	// 移除的实例不再被选中，其状态被清理
+	p = pb.Build(buildInfo("b"))
+	addr, _ = pick(t, p)
+	assert.Equal(t, "b", addr)
+	assert.NotContains(t, pb.stats, "a")
+}
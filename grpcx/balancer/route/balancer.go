@@ -0,0 +1,204 @@
+package route
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/to404hanga/pkg404/grpcx/balancer/leastrequest"
+	"github.com/to404hanga/pkg404/grpcx/resolver"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	gresolver "google.golang.org/grpc/resolver"
+)
+
+// LabelsKey 实例元数据中标签所在的 key，与 grpcx.Server 注册时写入的一致
+const LabelsKey = "labels"
+
+type builder struct {
+	name             string
+	router           *Router
+	newPickerBuilder func() base.PickerBuilder
+}
+
+// NewBuilder 按 router 的规则筛选实例，筛选后的实例交给 newPickerBuilder 创建的 PickerBuilder 选择，
+// newPickerBuilder 为 nil 时使用 least_request
+//
+// 返回的 Builder 需要通过 balancer.Register 注册，再在 service config 中以 name 引用，如
+//
+//	balancer.Register(route.NewBuilder("canary_least_request", router, nil))
+func NewBuilder(name string, router *Router, newPickerBuilder func() base.PickerBuilder) balancer.Builder {
+	return &builder{
+		name:             name,
+		router:           router,
+		newPickerBuilder: newPickerBuilder,
+	}
+}
+
+// Build 每个 ClientConn 使用独立的 PickerBuilder
+func (b *builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	return base.NewBalancerBuilder(b.name, NewPickerBuilder(b.router, b.newPickerBuilder), base.Config{
+		HealthCheck: true,
+	}).Build(cc, opts)
+}
+
+func (b *builder) Name() string {
+	return b.name
+}
+
+// PickerBuilder 每组标签对应一个内部的 PickerBuilder，重建 Picker 时保留内部 PickerBuilder 的状态，
+// 如进行中的请求数与动态权重
+type PickerBuilder struct {
+	router           *Router
+	newPickerBuilder func() base.PickerBuilder
+
+	lock sync.Mutex
+	// rest 没有命中规则或者没有符合标签的实例时使用，只包含没有被任何规则选中的实例，
+	// 所有实例都被规则选中时包含全部实例
+	rest base.PickerBuilder
+	// subsets selector => PickerBuilder
+	subsets map[string]base.PickerBuilder
+}
+
+// NewPickerBuilder newPickerBuilder 为 nil 时使用 least_request
+func NewPickerBuilder(router *Router, newPickerBuilder func() base.PickerBuilder) *PickerBuilder {
+	if newPickerBuilder == nil {
+		newPickerBuilder = func() base.PickerBuilder {
+			return leastrequest.NewPickerBuilder()
+		}
+	}
+	return &PickerBuilder{
+		router:           router,
+		newPickerBuilder: newPickerBuilder,
+		rest:             newPickerBuilder(),
+		subsets:          make(map[string]base.PickerBuilder),
+	}
+}
+
+func (p *PickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	picker := &Picker{pb: p, info: info}
+	picker.state.Store(p.build(info))
+	return picker
+}
+
+// build 按当前的规则为每组标签构建 Picker，调用方需要持有锁
+func (p *PickerBuilder) build(info base.PickerBuildInfo) *pickerState {
+	rules := p.router.load()
+	st := &pickerState{
+		rules:   rules,
+		subsets: make(map[string]balancer.Picker),
+	}
+	selected := make(map[balancer.SubConn]struct{})
+	used := make(map[string]struct{})
+	for _, rule := range *rules {
+		if len(rule.Labels) == 0 {
+			continue
+		}
+		sel := rule.selector()
+		if _, ok := used[sel]; ok {
+			continue
+		}
+		used[sel] = struct{}{}
+		ready := make(map[balancer.SubConn]base.SubConnInfo)
+		for sc, sci := range info.ReadySCs {
+			if matchLabels(rule.Labels, sci.Address) {
+				ready[sc] = sci
+				selected[sc] = struct{}{}
+			}
+		}
+		if len(ready) == 0 {
+			continue
+		}
+		pb, ok := p.subsets[sel]
+		if !ok {
+			pb = p.newPickerBuilder()
+			p.subsets[sel] = pb
+		}
+		st.subsets[sel] = pb.Build(base.PickerBuildInfo{ReadySCs: ready})
+	}
+	// 清理不再被规则使用的标签组
+	for sel := range p.subsets {
+		if _, ok := used[sel]; !ok {
+			delete(p.subsets, sel)
+		}
+	}
+	rest := make(map[balancer.SubConn]base.SubConnInfo)
+	for sc, sci := range info.ReadySCs {
+		if _, ok := selected[sc]; !ok {
+			rest[sc] = sci
+		}
+	}
+	if len(rest) == 0 {
+		rest = info.ReadySCs
+	}
+	st.rest = p.rest.Build(base.PickerBuildInfo{ReadySCs: rest})
+	return st
+}
+
+// pickerState 按某一版本的规则构建的 Picker
+type pickerState struct {
+	rules *[]Rule
+	// subsets selector => balancer.Picker，没有符合标签的实例时不存在
+	subsets map[string]balancer.Picker
+	rest    balancer.Picker
+}
+
+// Picker 命中规则时在带有规则标签的实例中选择，否则在没有被规则选中的实例中选择
+type Picker struct {
+	pb    *PickerBuilder
+	info  base.PickerBuildInfo
+	state atomic.Pointer[pickerState]
+}
+
+func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	st := p.current()
+	if rule, ok := match(info.Ctx, *st.rules); ok {
+		if picker, ok := st.subsets[rule.selector()]; ok {
+			return picker.Pick(info)
+		}
+	}
+	return st.rest.Pick(info)
+}
+
+// current 规则更新后使用新的规则重新构建一次，不需要等待 gRPC 重建 Picker
+func (p *Picker) current() *pickerState {
+	st := p.state.Load()
+	if st.rules == p.pb.router.load() {
+		return st
+	}
+	p.pb.lock.Lock()
+	defer p.pb.lock.Unlock()
+	st = p.state.Load()
+	if st.rules != p.pb.router.load() {
+		st = p.pb.build(p.info)
+		p.state.Store(st)
+	}
+	return st
+}
+
+// matchLabels 实例的标签来自注册中心，可能是 map[string]string，也可能是反序列化得到的 map[string]any
+func matchLabels(want map[string]string, addr gresolver.Address) bool {
+	md := resolver.MetadataFromAddress(addr)
+	if md == nil {
+		return false
+	}
+	for k, v := range want {
+		var got string
+		switch labels := md[LabelsKey].(type) {
+		case map[string]string:
+			got = labels[k]
+		case map[string]any:
+			got, _ = labels[k].(string)
+		default:
+			return false
+		}
+		if got != v {
+			return false
+		}
+	}
+	return true
+}
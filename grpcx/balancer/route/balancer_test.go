@@ -0,0 +1,155 @@
+package route
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/grpcx/resolver"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	gresolver "google.golang.org/grpc/resolver"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+// buildInfo addrs 为地址到 version 标签的映射，version 为空时实例没有标签
+func buildInfo(addrs map[string]string) base.PickerBuildInfo {
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for addr, version := range addrs {
+		a := gresolver.Address{Addr: addr}
+		if version != "" {
+			// 与从 etcd 反序列化得到的元数据一致
+			a = resolver.SetMetadata(a, resolver.Metadata{LabelsKey: map[string]any{"version": version}})
+		}
+		info.ReadySCs[&fakeSubConn{addr: addr}] = base.SubConnInfo{Address: a}
+	}
+	return info
+}
+
+func pick(t *testing.T, p balancer.Picker, kv ...string) string {
+	ctx := metadata.AppendToOutgoingContext(context.Background(), kv...)
+	pr, err := p.Pick(balancer.PickInfo{Ctx: ctx})
+	require.NoError(t, err)
+	pr.Done(balancer.DoneInfo{})
+	return pr.SubConn.(*fakeSubConn).addr
+}
+
+func TestPicker_Match(t *testing.T) {
+	router := NewRouter(Rule{
+		Name:   "tenant",
+		Match:  map[string][]string{"tenant": {"t1", "t2"}},
+		Labels: map[string]string{"version": "canary"},
+	}, Rule{
+		Name:   "debug",
+		Match:  map[string][]string{"x-debug": nil},
+		Labels: map[string]string{"version": "debug"},
+	})
+	p := NewPickerBuilder(router, nil).Build(buildInfo(map[string]string{
+		"a": "stable",
+		"b": "stable",
+		"c": "canary",
+		"d": "",
+	}))
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "c", pick(t, p, "tenant", "t1"))
+		assert.Equal(t, "c", pick(t, p, "tenant", "t2"))
+	}
+	// 没有命中规则时使用没有被规则选中的实例
+	served := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		served[pick(t, p, "tenant", "t3")]++
+	}
+	assert.Equal(t, map[string]int{"a": 10, "b": 10, "d": 10}, served)
+	// 命中规则但没有 debug 实例，同样回退到没有被规则选中的实例
+	served = make(map[string]int)
+	for i := 0; i < 30; i++ {
+		served[pick(t, p, "x-debug", "1")]++
+	}
+	assert.Equal(t, map[string]int{"a": 10, "b": 10, "d": 10}, served)
+
+	// 所有实例都被规则选中时使用全部实例
+	p = NewPickerBuilder(router, nil).Build(buildInfo(map[string]string{
+		"c": "canary",
+		"e": "debug",
+	}))
+	served = make(map[string]int)
+	for i := 0; i < 20; i++ {
+		served[pick(t, p, "tenant", "t3")]++
+	}
+	assert.Equal(t, map[string]int{"c": 10, "e": 10}, served)
+}
+
+func TestPicker_Percent(t *testing.T) {
+	router := NewRouter(Rule{
+		Name:    "canary",
+		Percent: 20,
+		HashKey: "user-id",
+		Labels:  map[string]string{"version": "canary"},
+	})
+	p := NewPickerBuilder(router, nil).Build(buildInfo(map[string]string{
+		"a": "stable",
+		"b": "canary",
+	}))
+
+	rule := router.Rules()[0]
+	matched := 0
+	for i := 0; i < 1000; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		if !rule.match(metadata.Pairs("user-id", user)) {
+			continue
+		}
+		matched++
+		// 命中的用户总是路由到 canary 实例
+		for j := 0; j < 3; j++ {
+			assert.Equal(t, "b", pick(t, p, "user-id", user))
+		}
+	}
+	assert.InDelta(t, 200, matched, 40)
+}
+
+func TestRouter_UpdateRules(t *testing.T) {
+	router := NewRouter()
+	pb := NewPickerBuilder(router, nil)
+	p := pb.Build(buildInfo(map[string]string{
+		"a": "stable",
+		"b": "canary",
+	}))
+	served := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		served[pick(t, p, "tenant", "t1")]++
+	}
+	assert.Equal(t, map[string]int{"a": 5, "b": 5}, served)
+
+	// 更新规则后不需要重建 Picker 即可生效
+	router.UpdateRules(Rule{
+		Name:   "tenant",
+		Match:  map[string][]string{"tenant": {"t1"}},
+		Labels: map[string]string{"version": "canary"},
+	})
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "b", pick(t, p, "tenant", "t1"))
+		assert.Equal(t, "a", pick(t, p, "tenant", "t2"))
+	}
+
+	router.UpdateRules(Rule{
+		Name:   "tenant",
+		Match:  map[string][]string{"tenant": {"t1"}},
+		Labels: map[string]string{"version": "stable"},
+	})
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "a", pick(t, p, "tenant", "t1"))
+	}
+	// 重建 Picker 时清理不再使用的标签组
+	pb.Build(buildInfo(map[string]string{"a": "stable", "b": "canary"}))
+	pb.lock.Lock()
+	assert.Len(t, pb.subsets, 1)
+	pb.lock.Unlock()
+}
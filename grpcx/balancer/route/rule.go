@@ -0,0 +1,119 @@
+package route
+
+import (
+	"context"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cespare/xxhash/v2"
+	"google.golang.org/grpc/metadata"
+)
+
+// Rule 一条路由规则，出站 metadata 匹配 Match 的请求按 Percent 的比例路由到带有 Labels 标签的实例
+type Rule struct {
+	Name string
+	// Match 出站 metadata 中每个 key 的取值都需要在对应的列表中，列表为空时只要求 key 存在，
+	// 为空时匹配所有请求。key 需要使用小写
+	Match map[string][]string
+	// Percent 匹配的请求中路由到 Labels 的比例，取值为 1~100，不大于 0 或大于 100 时视为 100
+	Percent int
+	// HashKey 按出站 metadata 中该 key 的取值计算比例，如 user-id，使同一个用户总是路由到相同的实例上，
+	// 为空或者请求中没有该 key 时随机计算
+	HashKey string
+	// Labels 目标实例需要带有的全部标签，如 version=canary
+	Labels map[string]string
+}
+
+func (r Rule) match(md metadata.MD) bool {
+	for key, vals := range r.Match {
+		got := md.Get(key)
+		if len(got) == 0 {
+			return false
+		}
+		if len(vals) > 0 && !contains(vals, got) {
+			return false
+		}
+	}
+	if r.Percent <= 0 || r.Percent >= 100 {
+		return true
+	}
+	if r.HashKey != "" {
+		if vals := md.Get(r.HashKey); len(vals) > 0 {
+			return xxhash.Sum64String(r.Name+"#"+vals[0])%100 < uint64(r.Percent)
+		}
+	}
+	return rand.IntN(100) < r.Percent
+}
+
+// contains 取值中任意一个在 vals 中即可
+func contains(vals, got []string) bool {
+	for _, g := range got {
+		for _, v := range vals {
+			if g == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selector Labels 的规范化表示，相同的 Labels 共用一组实例
+func (r Rule) selector() string {
+	keys := make([]string, 0, len(r.Labels))
+	for k := range r.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(r.Labels[k])
+	}
+	return sb.String()
+}
+
+// Router 持有路由规则，可以在运行时通过 UpdateRules 替换，已经建立的连接会立即使用新的规则
+type Router struct {
+	rules atomic.Pointer[[]Rule]
+}
+
+func NewRouter(rules ...Rule) *Router {
+	r := &Router{}
+	r.UpdateRules(rules...)
+	return r
+}
+
+// UpdateRules 整体替换规则，规则按顺序匹配
+func (r *Router) UpdateRules(rules ...Rule) {
+	rules = append([]Rule(nil), rules...)
+	r.rules.Store(&rules)
+}
+
+func (r *Router) Rules() []Rule {
+	return *r.load()
+}
+
+// load 每次 UpdateRules 都会得到新的指针，可以用于判断规则是否更新
+func (r *Router) load() *[]Rule {
+	return r.rules.Load()
+}
+
+// match 返回请求命中的规则，都没有命中时返回 false
+func match(ctx context.Context, rules []Rule) (Rule, bool) {
+	if len(rules) == 0 {
+		return Rule{}, false
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	for _, rule := range rules {
+		if len(rule.Labels) > 0 && rule.match(md) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
@@ -2,52 +2,129 @@ package smoothweightedroundrobin
 
 import (
 	"context"
-	"math"
+	"errors"
+	"sort"
 	"sync"
 
+	"github.com/to404hanga/pkg404/grpcx/resolver"
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
 	"google.golang.org/grpc/codes"
+	gresolver "google.golang.org/grpc/resolver"
 	"google.golang.org/grpc/status"
 )
 
-const Name = "smooth_weighted_round_robin"
+const (
+	Name = "smooth_weighted_round_robin"
+	// DefaultWeight 实例元数据中没有 weight 或 weight 不大于 0 时使用的权重
+	DefaultWeight = resolver.DefaultWeight
+	// deadlinePenalty 超时时降低的有效权重
+	deadlinePenalty = 10
+)
 
-func newBuilder() balancer.Builder {
-	return base.NewBalancerBuilder(Name, &PickerBuilder{}, base.Config{
-		HealthCheck: true,
-	})
+type builder struct{}
+
+// Build 每个 ClientConn 使用独立的 PickerBuilder，避免不同连接之间共享动态权重
+func (builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := NewPickerBuilder()
+	return &weightBalancer{
+		Balancer: base.NewBalancerBuilder(Name, pb, base.Config{
+			HealthCheck: true,
+		}).Build(cc, opts),
+		pb: pb,
+	}
+}
+
+func (builder) Name() string {
+	return Name
 }
 
 func init() {
-	balancer.Register(newBuilder())
+	balancer.Register(builder{})
+}
+
+// weightBalancer base 的 balancer 只保留地址第一次出现时的属性，
+// 这里拦截解析结果记录最新的权重，使运行时修改的权重能够生效
+type weightBalancer struct {
+	balancer.Balancer
+	pb *PickerBuilder
 }
 
+func (b *weightBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	b.pb.updateWeights(s.ResolverState.Addresses)
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+// PickerBuilder 每次 ReadySCs 变化时重新构建 Picker，按地址保留动态权重
 type PickerBuilder struct {
-	picker *Picker
+	// lock 保护所有 weightConn 的权重，由 Picker 与 Done 回调共享
+	lock    sync.Mutex
+	conns   map[string]*weightConn
+	weights map[string]int
+}
+
+func NewPickerBuilder() *PickerBuilder {
+	return &PickerBuilder{
+		conns:   make(map[string]*weightConn),
+		weights: make(map[string]int),
+	}
+}
+
+func (p *PickerBuilder) updateWeights(addrs []gresolver.Address) {
+	weights := make(map[string]int, len(addrs))
+	for _, addr := range addrs {
+		weights[addr.Addr] = resolver.Weight(addr)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.weights = weights
 }
 
 func (p *PickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
-	if p.picker == nil {
-		conns := make([]*weightConn, 0, len(info.ReadySCs))
-		for sc, sci := range info.ReadySCs {
-			md, _ := sci.Address.Metadata.(map[string]any)
-			weightVal := md["weight"]
-			weight, _ := weightVal.(float64)
-			conns = append(conns, &weightConn{
-				SubConn:       sc,
-				weight:        int(weight),
-				currentWeight: int(weight),
-			})
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	conns := make([]*weightConn, 0, len(info.ReadySCs))
+	alive := make(map[string]struct{}, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		addr := sci.Address.Addr
+		alive[addr] = struct{}{}
+		weight, ok := p.weights[addr]
+		if !ok {
+			weight = resolver.Weight(sci.Address)
+		}
+		c, ok := p.conns[addr]
+		if !ok || c.weight != weight {
+			// 新实例或者静态权重被修改，重新初始化
+			c = &weightConn{
+				addr:            addr,
+				weight:          weight,
+				efficientWeight: weight,
+			}
+			p.conns[addr] = c
 		}
-		p.picker = &Picker{
-			conns: conns,
+		c.SubConn = sc
+		conns = append(conns, c)
+	}
+	for addr := range p.conns {
+		if _, ok := alive[addr]; !ok {
+			delete(p.conns, addr)
 		}
 	}
-	return p.picker
+	// 保证相同权重下的选择顺序是确定的
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].addr < conns[j].addr
+	})
+	return &Picker{
+		lock:  &p.lock,
+		conns: conns,
+	}
 }
 
 type Picker struct {
+	lock  *sync.Mutex
 	conns []*weightConn
 }
 
@@ -55,69 +132,68 @@ func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	if len(p.conns) == 0 {
 		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
 	}
+	p.lock.Lock()
 	var (
 		totalWeight int
 		res         *weightConn
 	)
 	for _, c := range p.conns {
-		c.mutex.Lock()
 		totalWeight += c.efficientWeight
 		c.currentWeight += c.efficientWeight
 		if res == nil || res.currentWeight < c.currentWeight {
 			res = c
 		}
-		c.mutex.Unlock()
 	}
-	res.mutex.Lock()
 	res.currentWeight -= totalWeight
-	res.mutex.Unlock()
+	// Build 会在持有锁时替换 SubConn
+	sc := res.SubConn
+	p.lock.Unlock()
 	return balancer.PickResult{
-		SubConn: res.SubConn,
+		SubConn: sc,
 		Done: func(di balancer.DoneInfo) {
-			res.mutex.Lock()
-			defer res.mutex.Unlock()
-			if di.Err != nil && res.efficientWeight == 0 {
-				return
-			}
-			switch di.Err {
-			case nil:
-				if res.efficientWeight == math.MaxUint32 {
-					return
-				}
-				// 增加权重
-				res.efficientWeight++
-			case context.DeadlineExceeded:
-				// 超时可以考虑动态调整
-				// 比如，第一次超时降低 1，第二次超时降低 2，第三次超时降低 4
-				// 第 n 次超时降低 2^(n-1)
-				res.efficientWeight -= 10
-			default:
-				code := status.Code(di.Err)
-				switch code {
-				case codes.Unauthenticated:
-					// 熔断
-					res.efficientWeight = 1
-				case codes.ResourceExhausted:
-					res.efficientWeight >>= 1
-				case codes.Aborted:
-					// 降级
-					res.efficientWeight >>= 1
-				default:
-					if res.efficientWeight == 1 {
-						// 降无可降
-						return
-					}
-					res.efficientWeight--
-				}
-			}
+			p.lock.Lock()
+			defer p.lock.Unlock()
+			res.adjust(di.Err)
 		},
 	}, nil
 }
 
 type weightConn struct {
 	balancer.SubConn
-	mutex           sync.Mutex
+	addr string
+	// weight 元数据中的静态权重，也是有效权重的上限
 	weight          int
 	currentWeight   int
 	efficientWeight int
 }
+
+// adjust 根据调用结果调整有效权重，有效权重的范围为 [1, weight]
+func (c *weightConn) adjust(err error) {
+	switch {
+	case err == nil:
+		// 恢复权重
+		c.efficientWeight++
+	case errors.Is(err, context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded:
+		// 超时可以考虑动态调整
+		// 比如，第一次超时降低 1，第二次超时降低 2，第三次超时降低 4
+		// 第 n 次超时降低 2^(n-1)
+		c.efficientWeight -= deadlinePenalty
+	default:
+		switch status.Code(err) {
+		case codes.Unauthenticated:
+			// 熔断
+			c.efficientWeight = 1
+		case codes.ResourceExhausted, codes.Aborted:
+			// 限流或降级
+			c.efficientWeight >>= 1
+		default:
+			c.efficientWeight--
+		}
+	}
+	if c.efficientWeight < 1 {
+		c.efficientWeight = 1
+	}
+	if c.efficientWeight > c.weight {
+		c.efficientWeight = c.weight
+	}
+}
@@ -0,0 +1,151 @@
+package smoothweightedroundrobin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/grpcx/resolver"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	gresolver "google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+func newSubConn(addr string, weight any) (balancer.SubConn, base.SubConnInfo) {
+	address := gresolver.Address{Addr: addr}
+	if weight != nil {
+		address = resolver.SetMetadata(address, resolver.Metadata{"weight": weight})
+	}
+	return &fakeSubConn{addr: addr}, base.SubConnInfo{Address: address}
+}
+
+func buildInfo(scs ...any) base.PickerBuildInfo {
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for i := 0; i < len(scs); i += 2 {
+		sc, sci := newSubConn(scs[i].(string), scs[i+1])
+		info.ReadySCs[sc] = sci
+	}
+	return info
+}
+
+func pickN(t *testing.T, p balancer.Picker, n int, err error) []string {
+	res := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		pr, pickErr := p.Pick(balancer.PickInfo{})
+		require.NoError(t, pickErr)
+		res = append(res, pr.SubConn.(*fakeSubConn).addr)
+		pr.Done(balancer.DoneInfo{Err: err})
+	}
+	return res
+}
+
+func TestPicker_Distribution(t *testing.T) {
+	testCases := []struct {
+		name string
+		info base.PickerBuildInfo
+		want []string
+	}{
+		{
+			name: "经典 5:1:1",
+			info: buildInfo("a", float64(5), "b", float64(1), "c", float64(1)),
+			want: []string{"a", "a", "b", "a", "c", "a", "a"},
+		},
+		{
+			name: "没有权重时使用默认权重",
+			info: buildInfo("a", nil, "b", nil),
+			want: []string{"a", "b", "a", "b"},
+		},
+		{
+			name: "int 类型的权重",
+			info: buildInfo("a", 2, "b", 1),
+			want: []string{"a", "b", "a", "a", "b", "a"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewPickerBuilder().Build(tc.info)
+			assert.Equal(t, tc.want, pickN(t, p, len(tc.want), nil))
+		})
+	}
+}
+
+func TestPickerBuilder_Membership(t *testing.T) {
+	pb := NewPickerBuilder()
+
+	_, err := pb.Build(base.PickerBuildInfo{}).Pick(balancer.PickInfo{})
+	assert.ErrorIs(t, err, balancer.ErrNoSubConnAvailable)
+
+	p := pb.Build(buildInfo("a", float64(1)))
+	assert.Equal(t, []string{"a", "a"}, pickN(t, p, 2, nil))
+
+	// 新增实例后立即参与负载均衡
+	p = pb.Build(buildInfo("a", float64(1), "b", float64(1)))
+	picked := pickN(t, p, 4, nil)
+	assert.ElementsMatch(t, []string{"a", "a", "b", "b"}, picked)
+
+	// 失败降低的有效权重在重建后保留
+	p = pb.Build(buildInfo("a", float64(4), "b", float64(4)))
+	failed := 0
+	for failed < 2 {
+		pr, err := p.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		if pr.SubConn.(*fakeSubConn).addr == "b" {
+			pr.Done(balancer.DoneInfo{Err: status.Error(codes.Internal, "")})
+			failed++
+			continue
+		}
+		pr.Done(balancer.DoneInfo{})
+	}
+	assert.Equal(t, 2, pb.conns["b"].efficientWeight)
+	pb.Build(buildInfo("a", float64(4), "b", float64(4), "c", float64(3)))
+	assert.Equal(t, 2, pb.conns["b"].efficientWeight)
+	assert.Equal(t, 3, pb.conns["c"].efficientWeight)
+
+	// 移除的实例不再被选中，其状态被清理
+	p = pb.Build(buildInfo("c", float64(3)))
+	assert.Equal(t, []string{"c", "c", "c"}, pickN(t, p, 3, nil))
+	assert.NotContains(t, pb.conns, "a")
+	assert.NotContains(t, pb.conns, "b")
+
+	// 静态权重修改后重新初始化
+	pb.updateWeights([]gresolver.Address{resolver.SetMetadata(gresolver.Address{Addr: "c"}, resolver.Metadata{"weight": float64(8)})})
+	pb.Build(buildInfo("c", float64(3)))
+	assert.Equal(t, 8, pb.conns["c"].weight)
+	assert.Equal(t, 8, pb.conns["c"].efficientWeight)
+}
+
+func TestWeightConn_Adjust(t *testing.T) {
+	testCases := []struct {
+		name  string
+		start int
+		err   error
+		want  int
+	}{
+		{name: "成功不超过静态权重", start: 20, err: nil, want: 20},
+		{name: "成功恢复权重", start: 5, err: nil, want: 6},
+		{name: "超时", start: 15, err: context.DeadlineExceeded, want: 5},
+		{name: "超时状态码", start: 15, err: status.Error(codes.DeadlineExceeded, "超时"), want: 5},
+		{name: "超时不低于 1", start: 5, err: context.DeadlineExceeded, want: 1},
+		{name: "未认证", start: 10, err: status.Error(codes.Unauthenticated, ""), want: 1},
+		{name: "限流", start: 10, err: status.Error(codes.ResourceExhausted, ""), want: 5},
+		{name: "降级", start: 10, err: status.Error(codes.Aborted, ""), want: 5},
+		{name: "其他错误", start: 10, err: status.Error(codes.Internal, ""), want: 9},
+		{name: "其他错误不低于 1", start: 1, err: status.Error(codes.Internal, ""), want: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &weightConn{weight: 20, efficientWeight: tc.start}
+			c.adjust(tc.err)
+			assert.Equal(t, tc.want, c.efficientWeight)
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package grpcx
+
+import (
+	"fmt"
+
+	"github.com/to404hanga/pkg404/grpcx/balancer/smoothweightedroundrobin"
+	"github.com/to404hanga/pkg404/grpcx/registry"
+	"github.com/to404hanga/pkg404/grpcx/resolver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// NewClientConn 通过 reg 发现由 Server 注册的名为 name 的服务
+//
+// 默认使用明文传输与 smooth_weighted_round_robin 负载均衡，均可通过 opts 覆盖
+func NewClientConn(name string, reg registry.Registry, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithResolvers(resolver.NewRegistryBuilder(reg)),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{%q:{}}]}`, smoothweightedroundrobin.Name)),
+	}
+	dialOpts = append(dialOpts, opts...)
+	return grpc.NewClient(resolver.RegistryScheme+":///"+name, dialOpts...)
+}
@@ -0,0 +1,156 @@
+package grpcx
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/grpcx/registry"
+	"github.com/to404hanga/pkg404/grpcx/resolver"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/naming/endpoints"
+	"go.etcd.io/etcd/server/v3/embed"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	gresolver "google.golang.org/grpc/resolver"
+)
+
+type fakeResolverConn struct {
+	gresolver.ClientConn
+	states chan gresolver.State
+}
+
+func (c *fakeResolverConn) UpdateState(state gresolver.State) error {
+	c.states <- state
+	return nil
+}
+
+// startEtcd 启动内嵌的 etcd 并返回客户端
+func startEtcd(t *testing.T) *clientv3.Client {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.Name + "=" + peerURL.String()
+	e, err := embed.StartEtcd(cfg)
+	require.NoError(t, err)
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd 启动超时")
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{clientURL.String()},
+		DialTimeout: 3 * time.Second,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = cli.Close()
+	})
+	return cli
+}
+
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+// startHealthServer 启动一个只提供健康检查的 gRPC 服务，who 的状态用于区分实例
+func startHealthServer(t *testing.T, who healthpb.HealthCheckResponse_ServingStatus) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	hs := health.NewServer()
+	hs.SetServingStatus("who", who)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, hs)
+	go func() {
+		_ = server.Serve(l)
+	}()
+	t.Cleanup(server.Stop)
+	return l.Addr().String()
+}
+
+func TestNewClientConn(t *testing.T) {
+	cli := startEtcd(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	em, err := endpoints.NewManager(cli, registry.ServiceKey("user"))
+	require.NoError(t, err)
+	addrs := map[string]string{
+		"s1": startHealthServer(t, healthpb.HealthCheckResponse_SERVING),
+		"s2": startHealthServer(t, healthpb.HealthCheckResponse_NOT_SERVING),
+	}
+	for _, addr := range addrs {
+		err = em.AddEndpoint(ctx, registry.ServiceKey("user")+"/"+addr, endpoints.Endpoint{
+			Addr:     addr,
+			Metadata: map[string]any{"weight": 10},
+		})
+		require.NoError(t, err)
+	}
+
+	t.Run("实例元数据写入地址", func(t *testing.T) {
+		cc := &fakeResolverConn{states: make(chan gresolver.State, 10)}
+		r, err := resolver.NewEtcdBuilder(cli).Build(gresolver.Target{URL: url.URL{Scheme: resolver.EtcdScheme, Path: "/" + registry.ServiceKey("user")}}, cc, gresolver.BuildOptions{})
+		require.NoError(t, err)
+		defer r.Close()
+		select {
+		case state := <-cc.states:
+			require.Len(t, state.Addresses, 2)
+			for _, addr := range state.Addresses {
+				assert.Equal(t, resolver.Metadata{"weight": float64(10)}, resolver.MetadataFromAddress(addr))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("没有收到解析结果")
+		}
+	})
+
+	cc, err := NewClientConn("user", registry.NewEtcd(cli))
+	require.NoError(t, err)
+	defer cc.Close()
+	client := healthpb.NewHealthClient(cc)
+
+	// 以 who 的状态判断请求落到了哪个实例
+	servedBy := func() string {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "who"})
+		require.NoError(t, err)
+		if resp.GetStatus() == healthpb.HealthCheckResponse_SERVING {
+			return "s1"
+		}
+		return "s2"
+	}
+	// 等待两个实例都就绪
+	require.Eventually(t, func() bool {
+		return servedBy() == "s2"
+	}, 5*time.Second, 10*time.Millisecond)
+	served := make(map[string]int)
+	for i := 0; i < 40; i++ {
+		served[servedBy()]++
+	}
+	// 权重相同时两个实例收到的请求数相同
+	assert.Equal(t, 20, served["s1"])
+	assert.Equal(t, 20, served["s2"])
+
+	// 删除实例后请求全部落到剩余的实例上
+	require.NoError(t, em.DeleteEndpoint(ctx, registry.ServiceKey("user")+"/"+addrs["s2"]))
+	assert.Eventually(t, func() bool {
+		for i := 0; i < 10; i++ {
+			if servedBy() != "s1" {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 50*time.Millisecond)
+}
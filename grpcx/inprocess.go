@@ -0,0 +1,135 @@
+package grpcx
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// InProcessConn 不经过网络直接调用同一进程内的服务实现，如供 HTTP 网关使用
+//
+// 它同时实现了 grpc.ServiceRegistrar 与 grpc.ClientConnInterface，服务需要像注册到 grpc.Server 一样注册到它上面。
+// 只支持一元调用，请求与响应必须是 protobuf 消息
+type InProcessConn struct {
+	interceptor grpc.UnaryServerInterceptor
+
+	lock     sync.RWMutex
+	services map[string]*inProcessService
+}
+
+type inProcessService struct {
+	impl    any
+	methods map[string]grpc.MethodDesc
+}
+
+// NewInProcessConn interceptors 按顺序执行，与 grpc.ChainUnaryInterceptor 一致
+func NewInProcessConn(interceptors ...grpc.UnaryServerInterceptor) *InProcessConn {
+	c := &InProcessConn{
+		services: make(map[string]*inProcessService),
+	}
+	if len(interceptors) > 0 {
+		c.interceptor = chainUnary(interceptors)
+	}
+	return c
+}
+
+func (c *InProcessConn) RegisterService(desc *grpc.ServiceDesc, impl any) {
+	svc := &inProcessService{
+		impl:    impl,
+		methods: make(map[string]grpc.MethodDesc, len(desc.Methods)),
+	}
+	for _, m := range desc.Methods {
+		svc.methods[m.MethodName] = m
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.services[desc.ServiceName] = svc
+}
+
+// Invoke 出站的 metadata 会作为服务端的入站 metadata，响应通过 proto.Merge 复制到 reply
+func (c *InProcessConn) Invoke(ctx context.Context, method string, args any, reply any, opts ...grpc.CallOption) error {
+	service, name, ok := splitMethod(method)
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "grpcx: 方法名 %s 格式错误", method)
+	}
+	c.lock.RLock()
+	svc, ok := c.services[service]
+	c.lock.RUnlock()
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "grpcx: 未注册服务 %s", service)
+	}
+	md, ok := svc.methods[name]
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "grpcx: 服务 %s 没有方法 %s", service, name)
+	}
+	in, ok := args.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "grpcx: 请求 %T 不是 protobuf 消息", args)
+	}
+	out, ok := reply.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "grpcx: 响应 %T 不是 protobuf 消息", reply)
+	}
+	if outgoing, ok := metadata.FromOutgoingContext(ctx); ok {
+		ctx = metadata.NewIncomingContext(ctx, outgoing.Copy())
+	}
+	// 服务实现可能修改请求，这里复制一份
+	dec := func(v any) error {
+		msg, ok := v.(proto.Message)
+		if !ok {
+			return status.Errorf(codes.Internal, "grpcx: 请求 %T 不是 protobuf 消息", v)
+		}
+		proto.Merge(msg, in)
+		return nil
+	}
+	var interceptor grpc.UnaryServerInterceptor
+	if c.interceptor != nil {
+		interceptor = func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			return c.interceptor(ctx, req, &grpc.UnaryServerInfo{Server: svc.impl, FullMethod: method}, handler)
+		}
+	}
+	resp, err := md.Handler(svc.impl, ctx, dec, interceptor)
+	if err != nil {
+		return err
+	}
+	msg, ok := resp.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "grpcx: 响应 %T 不是 protobuf 消息", resp)
+	}
+	proto.Reset(out)
+	proto.Merge(out, msg)
+	return nil
+}
+
+func (c *InProcessConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, status.Errorf(codes.Unimplemented, "grpcx: InProcessConn 不支持流式调用 %s", method)
+}
+
+// splitMethod /user.UserService/GetUser 拆分为 user.UserService 与 GetUser
+func splitMethod(method string) (string, string, bool) {
+	method = strings.TrimPrefix(method, "/")
+	i := strings.LastIndex(method, "/")
+	if i <= 0 || i == len(method)-1 {
+		return "", "", false
+	}
+	return method[:i], method[i+1:], true
+}
+
+func chainUnary(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, h := interceptors[i], next
+			next = func(ctx context.Context, req any) (any, error) {
+				return interceptor(ctx, req, info, h)
+			}
+		}
+		return next(ctx, req)
+	}
+}
@@ -0,0 +1,50 @@
+package grpcx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestInProcessConn(t *testing.T) {
+	var calls []string
+	record := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			calls = append(calls, name+":"+info.FullMethod+":"+md.Get("app")[0])
+			return handler(ctx, req)
+		}
+	}
+	conn := NewInProcessConn(record("first"), record("second"))
+	hs := health.NewServer()
+	hs.SetServingStatus("user", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(conn, hs)
+	client := healthpb.NewHealthClient(conn)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "app", "gateway")
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "user"})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+	assert.Equal(t, []string{
+		"first:" + healthpb.Health_Check_FullMethodName + ":gateway",
+		"second:" + healthpb.Health_Check_FullMethodName + ":gateway",
+	}, calls)
+
+	// 服务端的错误原样返回
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "order"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// 流式调用与未注册的服务
+	_, err = client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "user"})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+	err = conn.Invoke(ctx, "/user.UserService/GetUser", &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+	"sync/atomic"
+
+	"github.com/to404hanga/pkg404/grpcx/interceptor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Source 调用方身份的来源
+type Source int
+
+const (
+	// SourceSPIFFE 客户端证书 URI SAN 中的 SPIFFE ID
+	SourceSPIFFE Source = iota + 1
+	// SourceCN 客户端证书的 CN，证书中没有 SPIFFE ID 时使用
+	SourceCN
+	// SourceToken metadata 中的服务间调用令牌
+	SourceToken
+)
+
+// Identity 通过认证的调用方身份
+type Identity struct {
+	Name   string
+	Source Source
+}
+
+type identityKey struct{}
+
+// IdentityFromContext 服务端拦截器放入 context 的调用方身份
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// Rule 方法的白名单
+type Rule struct {
+	// Method 以 / 结尾时按前缀匹配，如 /user.UserService/，否则按完整方法名匹配，为空时匹配所有方法
+	Method string
+	// Allow 允许的调用方，* 表示任意通过认证的调用方，以 / 结尾时按前缀匹配，
+	// 如 spiffe://example.org/ns/prod/，否则按身份完全匹配
+	Allow []string
+}
+
+func (r Rule) match(method string) bool {
+	if r.Method == "" {
+		return true
+	}
+	if strings.HasSuffix(r.Method, "/") {
+		return strings.HasPrefix(method, r.Method)
+	}
+	return method == r.Method
+}
+
+func (r Rule) allow(name string) bool {
+	for _, a := range r.Allow {
+		if a == "*" || a == name || strings.HasSuffix(a, "/") && strings.HasPrefix(name, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// InterceptorBuilder 服务间调用的认证与授权
+//
+// 调用方的身份来自 mTLS 校验过的客户端证书，以及 metadata 中的令牌（需要 WithTokenKey）。
+// 没有任何身份或者令牌无效时返回 Unauthenticated，方法没有匹配的规则或者身份不在白名单中时返回 PermissionDenied。
+// grpcx.Server 启用 mTLS 时，就绪检查使用的身份同样需要被允许调用 grpc.health.v1.Health/Check
+type InterceptorBuilder struct {
+	rules    atomic.Pointer[[]Rule]
+	tokenKey []byte
+	audience string
+}
+
+type Option func(b *InterceptorBuilder)
+
+// WithTokenKey 校验 metadata 中 TokenKey 对应的令牌，只接受被调方为 audience 的令牌，未设置时忽略令牌
+//
+// 令牌使用共享的 key 签名，持有 key 的服务可以冒充其他调用方，
+// 因此按令牌身份配置的白名单只能区分是否持有 key，需要区分具体调用方时使用 mTLS 证书中的身份
+func WithTokenKey(key []byte, audience string) Option {
+	return func(b *InterceptorBuilder) {
+		b.tokenKey = key
+		b.audience = audience
+	}
+}
+
+// NewInterceptorBuilder rules 按顺序匹配，使用第一条匹配方法的规则
+func NewInterceptorBuilder(rules []Rule, opts ...Option) *InterceptorBuilder {
+	b := &InterceptorBuilder{}
+	b.UpdateRules(rules...)
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// UpdateRules 整体替换规则，之后的请求立即使用新的规则
+func (b *InterceptorBuilder) UpdateRules(rules ...Rule) {
+	rules = append([]Rule(nil), rules...)
+	b.rules.Store(&rules)
+}
+
+func (b *InterceptorBuilder) Rules() []Rule {
+	return *b.rules.Load()
+}
+
+// BuildServerUnaryInterceptor 通过后将调用方身份放入 context，可以通过 IdentityFromContext 获取
+func (b *InterceptorBuilder) BuildServerUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := b.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (b *InterceptorBuilder) BuildServerStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := b.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &interceptor.ServerStream{
+			ServerStream: ss,
+			Ctx:          ctx,
+		})
+	}
+}
+
+func (b *InterceptorBuilder) authorize(ctx context.Context, method string) (context.Context, error) {
+	ids, err := b.identities(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	if len(ids) == 0 {
+		return ctx, status.Error(codes.Unauthenticated, "缺少调用方身份")
+	}
+	for _, rule := range b.Rules() {
+		if !rule.match(method) {
+			continue
+		}
+		for _, id := range ids {
+			if rule.allow(id.Name) {
+				return context.WithValue(ctx, identityKey{}, id), nil
+			}
+		}
+		break
+	}
+	return ctx, status.Errorf(codes.PermissionDenied, "%s 无权调用 %s", ids[0].Name, method)
+}
+
+// identities 依次为证书中的身份与令牌中的身份，令牌无效时返回 Unauthenticated
+func (b *InterceptorBuilder) identities(ctx context.Context) ([]Identity, error) {
+	var ids []Identity
+	if id, ok := certIdentity(ctx); ok {
+		ids = append(ids, id)
+	}
+	if len(b.tokenKey) == 0 {
+		return ids, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(TokenKey)
+	if len(tokens) == 0 {
+		return ids, nil
+	}
+	subject, err := ParseToken(b.tokenKey, tokens[0], b.audience)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "令牌无效: %s", err)
+	}
+	return append(ids, Identity{Name: subject, Source: SourceToken}), nil
+}
+
+// certIdentity 只使用校验通过的证书链，证书中有 SPIFFE ID 时优先使用
+func certIdentity(ctx context.Context) (Identity, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return Identity{}, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return Identity{}, false
+	}
+	return leafIdentity(info.State.VerifiedChains[0][0])
+}
+
+func leafIdentity(cert *x509.Certificate) (Identity, bool) {
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			return Identity{Name: u.String(), Source: SourceSPIFFE}, true
+		}
+	}
+	if cert.Subject.CommonName != "" {
+		return Identity{Name: cert.Subject.CommonName, Source: SourceCN}, true
+	}
+	return Identity{}, false
+}
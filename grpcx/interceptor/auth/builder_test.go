@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/grpcx/mtls"
+	"github.com/to404hanga/pkg404/grpcx/mtls/mtlstest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var tokenKey = []byte("secret")
+
+// startServer 返回服务端的地址，服务端记录最近一次请求的调用方身份
+func startServer(t *testing.T, b *InterceptorBuilder, opts ...grpc.ServerOption) (string, *Identity) {
+	var got Identity
+	opts = append(opts, grpc.ChainUnaryInterceptor(b.BuildServerUnaryInterceptor(),
+		func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			got, _ = IdentityFromContext(ctx)
+			return handler(ctx, req)
+		}))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(opts...)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(l)
+	}()
+	t.Cleanup(server.Stop)
+	return l.Addr().String(), &got
+}
+
+func check(t *testing.T, addr string, opts ...grpc.DialOption) error {
+	cc, err := grpc.NewClient(addr, opts...)
+	require.NoError(t, err)
+	defer cc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func reloader(t *testing.T, ca *mtlstest.CA, cn string, sans ...string) *mtls.Reloader {
+	leaf, err := ca.Issue(cn, sans...)
+	require.NoError(t, err)
+	certFile, keyFile, caFile, err := mtlstest.WriteFiles(t.TempDir(), cn, leaf, ca)
+	require.NoError(t, err)
+	r, err := mtls.NewReloader(certFile, keyFile, caFile)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = r.Close()
+	})
+	return r
+}
+
+func TestInterceptorBuilder_Certificate(t *testing.T) {
+	ca, err := mtlstest.NewCA("ca")
+	require.NoError(t, err)
+	b := NewInterceptorBuilder([]Rule{
+		{Method: healthpb.Health_Check_FullMethodName, Allow: []string{"spiffe://example.org/ns/prod/", "order"}},
+		{Method: "/grpc.health.v1.Health/", Allow: []string{"*"}},
+	})
+	addr, got := startServer(t, b, grpc.Creds(mtls.ServerCredentials(reloader(t, ca, "server", "127.0.0.1"))))
+	dial := func(cn string, sans ...string) grpc.DialOption {
+		return grpc.WithTransportCredentials(mtls.ClientCredentials(reloader(t, ca, cn, sans...), ""))
+	}
+
+	// SPIFFE ID 优先于 CN
+	require.NoError(t, check(t, addr, dial("user", "spiffe://example.org/ns/prod/sa/user")))
+	assert.Equal(t, Identity{Name: "spiffe://example.org/ns/prod/sa/user", Source: SourceSPIFFE}, *got)
+	require.NoError(t, check(t, addr, dial("order")))
+	assert.Equal(t, Identity{Name: "order", Source: SourceCN}, *got)
+
+	err = check(t, addr, dial("user", "spiffe://example.org/ns/test/sa/user"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	err = check(t, addr, dial("payment"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// 规则更新后立即生效
+	b.UpdateRules(Rule{Method: healthpb.Health_Check_FullMethodName, Allow: []string{"payment"}})
+	assert.NoError(t, check(t, addr, dial("payment")))
+	err = check(t, addr, dial("order"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	// 没有匹配的规则
+	b.UpdateRules()
+	err = check(t, addr, dial("payment"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestInterceptorBuilder_Token(t *testing.T) {
+	b := NewInterceptorBuilder([]Rule{{Allow: []string{"order"}}}, WithTokenKey(tokenKey, "user"))
+	addr, got := startServer(t, b)
+	plain := grpc.WithTransportCredentials(insecure.NewCredentials())
+	token := func(key []byte, subject, audience string, ttl time.Duration) grpc.DialOption {
+		return grpc.WithPerRPCCredentials(NewTokenCredentials(key, subject, audience, ttl, WithInsecureTransport()))
+	}
+
+	require.NoError(t, check(t, addr, plain, token(tokenKey, "order", "user", time.Minute)))
+	assert.Equal(t, Identity{Name: "order", Source: SourceToken}, *got)
+
+	err := check(t, addr, plain, token(tokenKey, "payment", "user", time.Minute))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// 没有身份
+	err = check(t, addr, plain)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// 签名错误、过期与发给其他服务的令牌
+	for _, opt := range []grpc.DialOption{
+		token([]byte("other"), "order", "user", time.Minute),
+		token(tokenKey, "order", "user", -time.Minute),
+		token(tokenKey, "order", "payment", time.Minute),
+	} {
+		err = check(t, addr, plain, opt)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+
+	// 默认不允许在明文连接上传递令牌
+	_, err = grpc.NewClient(addr, plain, grpc.WithPerRPCCredentials(NewTokenCredentials(tokenKey, "order", "user", time.Minute)))
+	assert.Error(t, err)
+
+	signed, err := SignToken([]byte("other"), "order", "user", time.Minute)
+	require.NoError(t, err)
+	_, err = ParseToken(tokenKey, signed, "user")
+	assert.Error(t, err)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(TokenKey, "garbage"))
+	_, err = b.BuildServerUnaryInterceptor()(ctx, nil,
+		&grpc.UnaryServerInfo{FullMethod: healthpb.Health_Check_FullMethodName}, func(ctx context.Context, req any) (any, error) {
+			return nil, nil
+		})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
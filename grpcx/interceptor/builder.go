@@ -12,14 +12,20 @@ import (
 type Builder struct {
 }
 
-// PeerName 获取对端应用名称
+// PeerName 获取对端应用名称，优先使用 context 中的 Peer
 func (b *Builder) PeerName(ctx context.Context) string {
-	return b.grpcHeaderValue(ctx, "app")
+	if p, ok := PeerFromContext(ctx); ok {
+		return p.App
+	}
+	return b.grpcHeaderValue(ctx, AppKey)
 }
 
-// PeerIP 获取对端 IP
+// PeerIP 获取对端 IP，优先使用 context 中的 Peer
 func (b *Builder) PeerIP(ctx context.Context) string {
-	clientIP := b.grpcHeaderValue(ctx, "client-ip")
+	if p, ok := PeerFromContext(ctx); ok {
+		return p.IP
+	}
+	clientIP := b.grpcHeaderValue(ctx, ClientIPKey)
 	if clientIP != "" {
 		return clientIP
 	}
@@ -38,6 +44,14 @@ func (b *Builder) PeerIP(ctx context.Context) string {
 	return ""
 }
 
+// RequestID 获取请求 ID，优先使用 context 中的请求 ID
+func (b *Builder) RequestID(ctx context.Context) string {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return id
+	}
+	return b.grpcHeaderValue(ctx, RequestIDKey)
+}
+
 func (b *Builder) grpcHeaderValue(ctx context.Context, key string) string {
 	if key == "" {
 		return ""
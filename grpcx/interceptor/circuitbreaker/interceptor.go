@@ -2,30 +2,226 @@ package circuitbreaker
 
 import (
 	"context"
+	"sync"
 
 	"github.com/go-kratos/aegis/circuitbreaker"
+	"github.com/go-kratos/aegis/circuitbreaker/sre"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/to404hanga/pkg404/grpcx/interceptor"
+	"github.com/to404hanga/pkg404/prometheusx"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 type InterceptorBuilder struct {
-	breaker circuitbreaker.CircuitBreaker
+	sreOpts    []sre.Option
+	factory    func(method string) circuitbreaker.CircuitBreaker
+	classifier func(err error) bool
+	registerer prometheus.Registerer
+	counter    *prometheus.CounterOpts
+	gauge      *prometheus.GaugeOpts
+	requests   *prometheus.CounterVec
+	state      *prometheus.GaugeVec
+	breakers   sync.Map // key => *methodBreaker
+}
+
+type Option func(b *InterceptorBuilder)
+
+// WithSREOptions 配置默认工厂创建的 aegis SRE 熔断器的参数
+func WithSREOptions(opts ...sre.Option) Option {
+	return func(b *InterceptorBuilder) {
+		b.sreOpts = opts
+	}
+}
+
+// WithFactory 自定义每个方法的熔断器，设置后 WithSREOptions 不再生效，
+// key 在服务端为方法名，在客户端为 target 与方法名拼接，如 etcd:///user/user.UserService/Get
+func WithFactory(factory func(key string) circuitbreaker.CircuitBreaker) Option {
+	return func(b *InterceptorBuilder) {
+		if factory != nil {
+			b.factory = factory
+		}
+	}
+}
+
+// WithClassifier 自定义哪些错误计为失败，默认为 DefaultClassifier
+func WithClassifier(classifier func(err error) bool) Option {
+	return func(b *InterceptorBuilder) {
+		if classifier != nil {
+			b.classifier = classifier
+		}
+	}
+}
+
+// WithPrometheus 通过 namespace_subsystem_circuit_breaker_state 暴露每个熔断器的状态，1 为正在拒绝请求，
+// 重新放行后变为 0；通过 namespace_subsystem_circuit_breaker_requests_total 统计放行与拒绝的请求数，
+// result 为 allowed 或 rejected，拒绝的比例可以通过 rate 计算。method 标签与 WithFactory 的 key 一致
+func WithPrometheus(namespace, subsystem, instanceId string) Option {
+	return func(b *InterceptorBuilder) {
+		constLabels := map[string]string{
+			"instance_id": instanceId,
+		}
+		b.counter = &prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "circuit_breaker_requests_total",
+			Help:        "熔断器放行与拒绝的请求数",
+			ConstLabels: constLabels,
+		}
+		b.gauge = &prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "circuit_breaker_state",
+			Help:        "熔断器状态，1 为正在拒绝请求，0 为放行",
+			ConstLabels: constLabels,
+		}
+	}
+}
+
+// WithRegisterer WithPrometheus 的指标注册到 reg，默认为 prometheus.DefaultRegisterer
+func WithRegisterer(reg prometheus.Registerer) Option {
+	return func(b *InterceptorBuilder) {
+		b.registerer = reg
+	}
+}
+
+// NewInterceptorBuilder 每个方法在第一次被调用时创建独立的熔断器，客户端的每个 target 的每个方法使用独立的熔断器
+func NewInterceptorBuilder(opts ...Option) *InterceptorBuilder {
+	b := &InterceptorBuilder{
+		classifier: DefaultClassifier,
+	}
+	b.factory = func(string) circuitbreaker.CircuitBreaker {
+		return sre.NewBreaker(b.sreOpts...)
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.counter != nil {
+		b.requests = prometheusx.Register(b.registerer, prometheus.NewCounterVec(*b.counter, []string{"method", "result"}))
+		b.state = prometheusx.Register(b.registerer, prometheus.NewGaugeVec(*b.gauge, []string{"method"}))
+	}
+	return b
+}
+
+// DefaultClassifier 只有服务自身的故障才计为失败，参数错误、资源不存在等业务错误不影响熔断
+func DefaultClassifier(err error) bool {
+	switch status.Code(err) {
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DeadlineExceeded, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
 }
 
 func (ib *InterceptorBuilder) BuildServerUnaryInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
-		err = ib.breaker.Allow()
+		breaker := ib.breaker(info.FullMethod)
+		if !breaker.allow() {
+			return nil, status.Error(codes.Unavailable, "熔断")
+		}
+		resp, err = handler(ctx, req)
+		breaker.mark(err)
+		return
+	}
+}
+
+// BuildServerStreamInterceptor 在建立流时判断熔断，流结束后根据返回的错误更新熔断器
+func (ib *InterceptorBuilder) BuildServerStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		breaker := ib.breaker(info.FullMethod)
+		if !breaker.allow() {
+			return status.Error(codes.Unavailable, "熔断")
+		}
+		err := handler(srv, ss)
+		breaker.mark(err)
+		return err
+	}
+}
+
+// BuildClientUnaryInterceptor 下游不可用时快速失败
+func (ib *InterceptorBuilder) BuildClientUnaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		breaker := ib.breaker(cc.Target() + method)
+		if !breaker.allow() {
+			return status.Error(codes.Unavailable, "熔断")
+		}
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		breaker.mark(err)
+		return err
+	}
+}
+
+// BuildClientStreamInterceptor 在建立流之前判断熔断，流结束后根据结果更新熔断器
+func (ib *InterceptorBuilder) BuildClientStreamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		breaker := ib.breaker(cc.Target() + method)
+		if !breaker.allow() {
+			return nil, status.Error(codes.Unavailable, "熔断")
+		}
+		s, err := streamer(ctx, desc, cc, method, opts...)
 		if err != nil {
-			resp, err = handler(ctx, req)
-			if err == nil {
-				ib.breaker.MarkSuccess()
-			} else {
-				ib.breaker.MarkFailed()
-			}
-			return
-		}
-		ib.breaker.MarkFailed()
-		return nil, status.Error(codes.Unavailable, "熔断")
+			breaker.mark(err)
+			return nil, err
+		}
+		return interceptor.NewClientStream(ctx, s, desc, breaker.mark), nil
+	}
+}
+
+func (ib *InterceptorBuilder) breaker(key string) *methodBreaker {
+	if val, ok := ib.breakers.Load(key); ok {
+		return val.(*methodBreaker)
+	}
+	mb := &methodBreaker{
+		CircuitBreaker: ib.factory(key),
+		classifier:     ib.classifier,
+	}
+	if ib.requests != nil {
+		mb.allowed = ib.requests.WithLabelValues(key, "allowed")
+		mb.rejected = ib.requests.WithLabelValues(key, "rejected")
+		mb.state = ib.state.WithLabelValues(key)
+	}
+	val, _ := ib.breakers.LoadOrStore(key, mb)
+	return val.(*methodBreaker)
+}
+
+type methodBreaker struct {
+	circuitbreaker.CircuitBreaker
+	classifier func(err error) bool
+	allowed    prometheus.Counter
+	rejected   prometheus.Counter
+	state      prometheus.Gauge
+}
+
+// allow 与 aegis 的用法一致，被拒绝的请求同样计为失败
+func (mb *methodBreaker) allow() bool {
+	if err := mb.Allow(); err != nil {
+		mb.MarkFailed()
+		inc(mb.rejected)
+		mb.setState(1)
+		return false
+	}
+	inc(mb.allowed)
+	mb.setState(0)
+	return true
+}
+
+func (mb *methodBreaker) mark(err error) {
+	if err != nil && mb.classifier(err) {
+		mb.MarkFailed()
+	} else {
+		mb.MarkSuccess()
+	}
+}
+
+func inc(c prometheus.Counter) {
+	if c != nil {
+		c.Inc()
+	}
+}
+
+func (mb *methodBreaker) setState(val float64) {
+	if mb.state != nil {
+		mb.state.Set(val)
 	}
 }
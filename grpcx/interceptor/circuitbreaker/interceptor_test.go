@@ -0,0 +1,234 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-kratos/aegis/circuitbreaker"
+	"github.com/go-kratos/aegis/circuitbreaker/sre"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// fakeBreaker 记录 MarkSuccess 与 MarkFailed 的调用
+type fakeBreaker struct {
+	allowErr error
+	marks    []string
+}
+
+func (b *fakeBreaker) Allow() error { return b.allowErr }
+func (b *fakeBreaker) MarkSuccess() { b.marks = append(b.marks, "success") }
+func (b *fakeBreaker) MarkFailed()  { b.marks = append(b.marks, "failed") }
+
+func newConn(t *testing.T, target string) *grpc.ClientConn {
+	cc, err := grpc.NewClient("passthrough:///"+target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = cc.Close()
+	})
+	return cc
+}
+
+func invoker(err error) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return err
+	}
+}
+
+func TestInterceptorBuilder_ClientBreaker(t *testing.T) {
+	userConn, orderConn := newConn(t, "user"), newConn(t, "order")
+
+	testCases := []struct {
+		name string
+		// err 下游返回的错误
+		err      error
+		wantOpen bool
+	}{
+		{name: "下游不可用时熔断", err: status.Error(codes.Unavailable, ""), wantOpen: true},
+		{name: "下游超时时熔断", err: status.Error(codes.DeadlineExceeded, ""), wantOpen: true},
+		{name: "业务错误不熔断", err: status.Error(codes.NotFound, "")},
+		{name: "参数错误不熔断", err: status.Error(codes.InvalidArgument, "")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			interceptor := NewInterceptorBuilder(WithSREOptions(sre.WithRequest(10))).BuildClientUnaryInterceptor()
+			open := false
+			for i := 0; i < 200 && !open; i++ {
+				err := interceptor(context.Background(), "/user.UserService/Get", nil, nil, userConn, invoker(tc.err))
+				if status.Code(err) == codes.Unavailable && status.Convert(err).Message() == "熔断" {
+					open = true
+				}
+			}
+			assert.Equal(t, tc.wantOpen, open)
+			if !tc.wantOpen {
+				return
+			}
+			// 其他方法与其他 target 使用独立的熔断器
+			err := interceptor(context.Background(), "/user.UserService/List", nil, nil, userConn, invoker(nil))
+			assert.NoError(t, err)
+			err = interceptor(context.Background(), "/user.UserService/Get", nil, nil, orderConn, invoker(nil))
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestInterceptorBuilder_ClientStream(t *testing.T) {
+	cc := newConn(t, "user")
+	interceptor := NewInterceptorBuilder(WithSREOptions(sre.WithRequest(10))).BuildClientStreamInterceptor()
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return nil, status.Error(codes.Unavailable, "")
+	}
+	open := false
+	for i := 0; i < 200 && !open; i++ {
+		_, err := interceptor(context.Background(), &grpc.StreamDesc{}, cc, "/user.UserService/Watch", streamer)
+		open = status.Convert(err).Message() == "熔断"
+	}
+	assert.True(t, open)
+}
+
+func TestInterceptorBuilder_Unary(t *testing.T) {
+	notFound := status.Error(codes.NotFound, "")
+	testCases := []struct {
+		name       string
+		allowErr   error
+		handlerErr error
+		opts       []Option
+		wantCalled bool
+		wantCode   codes.Code
+		wantMarks  []string
+	}{
+		{
+			name:       "放行并成功",
+			wantCalled: true,
+			wantCode:   codes.OK,
+			wantMarks:  []string{"success"},
+		},
+		{
+			name:       "熔断时不执行业务",
+			allowErr:   circuitbreaker.ErrNotAllowed,
+			wantCalled: false,
+			wantCode:   codes.Unavailable,
+			wantMarks:  []string{"failed"},
+		},
+		{
+			name:       "服务故障计为失败",
+			handlerErr: status.Error(codes.Internal, ""),
+			wantCalled: true,
+			wantCode:   codes.Internal,
+			wantMarks:  []string{"failed"},
+		},
+		{
+			name:       "业务错误默认不计为失败",
+			handlerErr: notFound,
+			wantCalled: true,
+			wantCode:   codes.NotFound,
+			wantMarks:  []string{"success"},
+		},
+		{
+			name:       "自定义失败的判断",
+			handlerErr: notFound,
+			opts: []Option{WithClassifier(func(err error) bool {
+				return errors.Is(err, notFound)
+			})},
+			wantCalled: true,
+			wantCode:   codes.NotFound,
+			wantMarks:  []string{"failed"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			breaker := &fakeBreaker{allowErr: tc.allowErr}
+			opts := append([]Option{WithFactory(func(string) circuitbreaker.CircuitBreaker {
+				return breaker
+			})}, tc.opts...)
+			interceptor := NewInterceptorBuilder(opts...).BuildServerUnaryInterceptor()
+			called := false
+			_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Get"},
+				func(ctx context.Context, req any) (any, error) {
+					called = true
+					return nil, tc.handlerErr
+				})
+			assert.Equal(t, tc.wantCalled, called)
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			assert.Equal(t, tc.wantMarks, breaker.marks)
+		})
+	}
+}
+
+func TestInterceptorBuilder_PerMethod(t *testing.T) {
+	breakers := make(map[string]*fakeBreaker)
+	b := NewInterceptorBuilder(
+		WithFactory(func(method string) circuitbreaker.CircuitBreaker {
+			breaker := &fakeBreaker{}
+			if method == "/user.UserService/Get" {
+				breaker.allowErr = circuitbreaker.ErrNotAllowed
+			}
+			breakers[method] = breaker
+			return breaker
+		}),
+		WithPrometheus("pkg404", "grpcx", "test"),
+		WithRegisterer(prometheus.NewRegistry()),
+	)
+	interceptor := b.BuildServerUnaryInterceptor()
+	handler := func(ctx context.Context, req any) (any, error) {
+		return nil, nil
+	}
+	for i := 0; i < 3; i++ {
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Get"}, handler)
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/List"}, handler)
+		assert.NoError(t, err)
+	}
+	// 每个方法只创建一次熔断器
+	assert.Len(t, breakers, 2)
+	assert.Len(t, breakers["/user.UserService/Get"].marks, 3)
+	assert.Len(t, breakers["/user.UserService/List"].marks, 3)
+	assert.Equal(t, float64(3), testutil.ToFloat64(b.requests.WithLabelValues("/user.UserService/Get", "rejected")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(b.requests.WithLabelValues("/user.UserService/Get", "allowed")))
+	assert.Equal(t, float64(3), testutil.ToFloat64(b.requests.WithLabelValues("/user.UserService/List", "allowed")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(b.state.WithLabelValues("/user.UserService/Get")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(b.state.WithLabelValues("/user.UserService/List")))
+	// 重新放行后状态恢复为 0
+	breakers["/user.UserService/Get"].allowErr = nil
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Get"}, handler)
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), testutil.ToFloat64(b.state.WithLabelValues("/user.UserService/Get")))
+	breakers["/user.UserService/Get"].allowErr = circuitbreaker.ErrNotAllowed
+
+	// 流与 unary 共用同一个方法维度的熔断器
+	err = b.BuildServerStreamInterceptor()(nil, nil, &grpc.StreamServerInfo{FullMethod: "/user.UserService/Get"},
+		func(srv any, stream grpc.ServerStream) error {
+			t.Fatal("熔断时不应执行业务")
+			return nil
+		})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Len(t, breakers, 2)
+}
+
+func TestInterceptorBuilder_ClientUnary(t *testing.T) {
+	breaker := &fakeBreaker{}
+	b := NewInterceptorBuilder(WithFactory(func(string) circuitbreaker.CircuitBreaker {
+		return breaker
+	}))
+	interceptor := b.BuildClientUnaryInterceptor()
+	cc := newConn(t, "user")
+
+	assert.NoError(t, interceptor(context.Background(), "/user.UserService/Get", nil, nil, cc, invoker(nil)))
+	err := interceptor(context.Background(), "/user.UserService/Get", nil, nil, cc, invoker(status.Error(codes.Unavailable, "")))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, []string{"success", "failed"}, breaker.marks)
+
+	breaker.allowErr = circuitbreaker.ErrNotAllowed
+	err = interceptor(context.Background(), "/user.UserService/Get", nil, nil, cc, func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		t.Fatal("熔断时不应发出请求")
+		return nil
+	})
+	assert.Equal(t, "熔断", status.Convert(err).Message())
+}
@@ -0,0 +1,90 @@
+package identity
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/to404hanga/pkg404/grpcx/interceptor"
+	"github.com/to404hanga/pkg404/netx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// InterceptorBuilder 在调用方与服务端之间传递调用方的应用名称、IP 与请求 ID
+type InterceptorBuilder struct {
+	app string
+	ip  string
+	interceptor.Builder
+}
+
+// NewInterceptorBuilder app 为当前应用的名称，客户端拦截器以当前机器的出口 IP 作为 client-ip
+func NewInterceptorBuilder(app string) *InterceptorBuilder {
+	return &InterceptorBuilder{
+		app: app,
+		ip:  netx.GetOutboundIP(),
+	}
+}
+
+// BuildServerUnaryInterceptor 将调用方的身份解析为 interceptor.Peer 放入 context，调用方没有传递请求 ID 时生成一个
+//
+// 需要放在 logger、prometheus、ratelimit 等拦截器之前
+func (b *InterceptorBuilder) BuildServerUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(b.withPeer(ctx), req)
+	}
+}
+
+func (b *InterceptorBuilder) BuildServerStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &interceptor.ServerStream{
+			ServerStream: ss,
+			Ctx:          b.withPeer(ss.Context()),
+		})
+	}
+}
+
+// BuildClientUnaryInterceptor 在 metadata 中设置 app、client-ip 与请求 ID，已经设置的不会覆盖
+//
+// 请求 ID 来自 interceptor.WithRequestID，在服务端拦截器之后发起的调用会沿用上游的请求 ID
+func (b *InterceptorBuilder) BuildClientUnaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(b.outgoing(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+func (b *InterceptorBuilder) BuildClientStreamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(b.outgoing(ctx), desc, cc, method, opts...)
+	}
+}
+
+func (b *InterceptorBuilder) withPeer(ctx context.Context) context.Context {
+	p := interceptor.Peer{
+		App:       b.PeerName(ctx),
+		IP:        b.PeerIP(ctx),
+		RequestID: b.RequestID(ctx),
+	}
+	if p.RequestID == "" {
+		p.RequestID = uuid.NewString()
+	}
+	ctx = interceptor.WithRequestID(ctx, p.RequestID)
+	return interceptor.WithPeer(ctx, p)
+}
+
+func (b *InterceptorBuilder) outgoing(ctx context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	var kv []string
+	if b.app != "" && len(md.Get(interceptor.AppKey)) == 0 {
+		kv = append(kv, interceptor.AppKey, b.app)
+	}
+	if b.ip != "" && len(md.Get(interceptor.ClientIPKey)) == 0 {
+		kv = append(kv, interceptor.ClientIPKey, b.ip)
+	}
+	if id := interceptor.RequestIDFromContext(ctx); id != "" && len(md.Get(interceptor.RequestIDKey)) == 0 {
+		kv = append(kv, interceptor.RequestIDKey, id)
+	}
+	if len(kv) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
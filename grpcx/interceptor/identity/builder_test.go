@@ -0,0 +1,89 @@
+package identity
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/grpcx/interceptor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestInterceptorBuilder(t *testing.T) {
+	peers := make(chan interceptor.Peer, 2)
+	record := func(ctx context.Context) {
+		p, ok := interceptor.PeerFromContext(ctx)
+		require.True(t, ok)
+		assert.Equal(t, p.RequestID, interceptor.RequestIDFromContext(ctx))
+		peers <- p
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := NewInterceptorBuilder("user")
+	hs := health.NewServer()
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(server.BuildServerUnaryInterceptor(),
+			func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				record(ctx)
+				return handler(ctx, req)
+			}),
+		grpc.ChainStreamInterceptor(server.BuildServerStreamInterceptor(),
+			func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				record(ss.Context())
+				return handler(srv, ss)
+			}),
+	)
+	healthpb.RegisterHealthServer(s, hs)
+	go func() {
+		_ = s.Serve(l)
+	}()
+	defer s.Stop()
+
+	client := NewInterceptorBuilder("order")
+	client.ip = "10.0.0.1"
+	cc, err := grpc.NewClient(l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(client.BuildClientUnaryInterceptor()),
+		grpc.WithStreamInterceptor(client.BuildClientStreamInterceptor()))
+	require.NoError(t, err)
+	defer cc.Close()
+	hc := healthpb.NewHealthClient(cc)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 沿用 context 中的请求 ID
+	_, err = hc.Check(interceptor.WithRequestID(ctx, "req-1"), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, interceptor.Peer{App: "order", IP: "10.0.0.1", RequestID: "req-1"}, <-peers)
+
+	// 没有请求 ID 时由服务端生成，已经设置的 metadata 不会被覆盖
+	stream, err := hc.Watch(metadata.AppendToOutgoingContext(ctx, interceptor.AppKey, "gateway"), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	p := <-peers
+	assert.Equal(t, "gateway", p.App)
+	assert.Equal(t, "10.0.0.1", p.IP)
+	assert.NotEmpty(t, p.RequestID)
+}
+
+func TestInterceptorBuilder_PeerAddr(t *testing.T) {
+	var got interceptor.Peer
+	_, err := NewInterceptorBuilder("user").BuildServerUnaryInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req any) (any, error) {
+			got, _ = interceptor.PeerFromContext(ctx)
+			return nil, nil
+		})
+	require.NoError(t, err)
+	assert.Empty(t, got.App)
+	assert.Empty(t, got.IP)
+	assert.NotEmpty(t, got.RequestID)
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"runtime"
+	"sync/atomic"
 	"time"
 
+	"github.com/to404hanga/pkg404/errx"
 	"github.com/to404hanga/pkg404/grpcx/interceptor"
 	"github.com/to404hanga/pkg404/logger"
 	"google.golang.org/grpc"
@@ -25,37 +27,141 @@ func NewInterceptorBuilder(l logger.Logger) *InterceptorBuilder {
 func (b *InterceptorBuilder) BuildServerUnaryInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
 		start := time.Now()
-		event := "normal"
+		var stack string
 		defer func() {
-			cost := time.Since(start)
 			if rec := recover(); rec != nil {
-				switch re := rec.(type) {
-				case error:
-					err = re
-				default:
-					err = fmt.Errorf("%v", rec)
-				}
-				event = "recover"
-				stack := make([]byte, 4096)
-				stack = stack[:runtime.Stack(stack, true)]
-				err = status.New(codes.Internal, "panic, err"+err.Error()).Err()
+				stack, err = recoverErr(rec)
 			}
-			fields := []logger.Field{
-				logger.String("type", "unary"),
-				logger.Int64("cost", cost.Milliseconds()),
-				logger.String("event", event),
-				logger.String("method", info.FullMethod),
-				logger.String("peer", b.PeerName(ctx)),
-				logger.String("peer_ip", b.PeerIP(ctx)),
-			}
-			st, _ := status.FromError(err)
-			if st != nil {
-				fields = append(fields, logger.String("code", st.Code().String()))
-				fields = append(fields, logger.String("code_msg", st.Message()))
-			}
-			b.l.Info("RPC 调用", fields...)
+			b.l.Info("RPC 调用", b.serverFields(ctx, "unary", info.FullMethod, start, stack, err)...)
 		}()
 		resp, err = handler(ctx, req)
 		return
 	}
 }
+
+// BuildServerStreamInterceptor 在流结束时记录一条日志，包含收发的消息数
+func (b *InterceptorBuilder) BuildServerStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		start := time.Now()
+		var (
+			stack      string
+			sent, recv atomic.Int64
+		)
+		defer func() {
+			if rec := recover(); rec != nil {
+				stack, err = recoverErr(rec)
+			}
+			fields := b.serverFields(ss.Context(), "stream", info.FullMethod, start, stack, err)
+			fields = append(fields, logger.Int64("sent", sent.Load()), logger.Int64("recv", recv.Load()))
+			b.l.Info("RPC 调用", fields...)
+		}()
+		return handler(srv, &interceptor.ServerStream{
+			ServerStream: ss,
+			OnSend:       countMsg(&sent),
+			OnRecv:       countMsg(&recv),
+		})
+	}
+}
+
+// BuildClientUnaryInterceptor 记录发出的每个请求，target 为下游服务的地址
+func (b *InterceptorBuilder) BuildClientUnaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		b.l.Info("RPC 调用", clientFields("unary", method, cc.Target(), start, err)...)
+		return err
+	}
+}
+
+// BuildClientStreamInterceptor 在流结束时记录一条日志，包含收发的消息数
+func (b *InterceptorBuilder) BuildClientStreamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		var sent, recv atomic.Int64
+		finish := func(err error) {
+			fields := clientFields("stream", method, cc.Target(), start, err)
+			fields = append(fields, logger.Int64("sent", sent.Load()), logger.Int64("recv", recv.Load()))
+			b.l.Info("RPC 调用", fields...)
+		}
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			finish(err)
+			return nil, err
+		}
+		cs := interceptor.NewClientStream(ctx, s, desc, finish)
+		cs.OnSend = countMsg(&sent)
+		cs.OnRecv = countMsg(&recv)
+		return cs, nil
+	}
+}
+
+func (b *InterceptorBuilder) serverFields(ctx context.Context, typ, method string, start time.Time, stack string, err error) []logger.Field {
+	event := "normal"
+	if stack != "" {
+		event = "recover"
+	}
+	fields := []logger.Field{
+		logger.String("type", typ),
+		logger.Int64("cost", time.Since(start).Milliseconds()),
+		logger.String("event", event),
+		logger.String("method", method),
+		logger.String("peer", b.PeerName(ctx)),
+		logger.String("peer_ip", b.PeerIP(ctx)),
+	}
+	if id := b.RequestID(ctx); id != "" {
+		fields = append(fields, logger.String("request_id", id))
+	}
+	if stack != "" {
+		fields = append(fields, logger.String("stack", stack))
+	}
+	return append(fields, codeFields(err)...)
+}
+
+func clientFields(typ, method, target string, start time.Time, err error) []logger.Field {
+	fields := []logger.Field{
+		logger.String("type", typ),
+		logger.Int64("cost", time.Since(start).Milliseconds()),
+		logger.String("method", method),
+		logger.String("target", target),
+	}
+	return append(fields, codeFields(err)...)
+}
+
+// codeFields 按 errx.FromError 解析错误，业务错误额外记录 reason
+func codeFields(err error) []logger.Field {
+	e := errx.FromError(err)
+	if e == nil {
+		return nil
+	}
+	fields := []logger.Field{
+		logger.String("code", e.Code.String()),
+		logger.String("code_msg", e.Message),
+	}
+	if e.Reason != "" {
+		fields = append(fields, logger.String("reason", e.Reason))
+	}
+	return fields
+}
+
+// recoverErr 将 panic 转换为 Internal 错误，并返回当前的调用栈
+func recoverErr(rec any) (string, error) {
+	var err error
+	switch re := rec.(type) {
+	case error:
+		err = re
+	default:
+		err = fmt.Errorf("%v", rec)
+	}
+	stack := make([]byte, 4096)
+	stack = stack[:runtime.Stack(stack, true)]
+	return string(stack), status.New(codes.Internal, "panic, err"+err.Error()).Err()
+}
+
+// countMsg 统计成功收发的消息数
+func countMsg(cnt *atomic.Int64) func(any, error) {
+	return func(_ any, err error) {
+		if err == nil {
+			cnt.Add(1)
+		}
+	}
+}
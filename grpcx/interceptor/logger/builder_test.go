@@ -0,0 +1,167 @@
+package logger
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/errx"
+	pinterceptor "github.com/to404hanga/pkg404/grpcx/interceptor"
+	"github.com/to404hanga/pkg404/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// recordLogger 记录每条日志的字段
+type recordLogger struct {
+	logger.Logger
+	lock sync.Mutex
+	logs []map[string]any
+}
+
+func (l *recordLogger) Info(msg string, args ...logger.Field) {
+	fields := make(map[string]any, len(args))
+	for _, arg := range args {
+		fields[arg.Key] = arg.Val
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.logs = append(l.logs, fields)
+}
+
+func (l *recordLogger) entries() []map[string]any {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]map[string]any(nil), l.logs...)
+}
+
+func TestInterceptorBuilder_Stream(t *testing.T) {
+	serverLogs, clientLogs := &recordLogger{}, &recordLogger{}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	hs := health.NewServer()
+	server := grpc.NewServer(grpc.StreamInterceptor(NewInterceptorBuilder(serverLogs).BuildServerStreamInterceptor()))
+	healthpb.RegisterHealthServer(server, hs)
+	go func() {
+		_ = server.Serve(l)
+	}()
+	defer server.Stop()
+	cc, err := grpc.NewClient(l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStreamInterceptor(NewInterceptorBuilder(clientLogs).BuildClientStreamInterceptor()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+	stream, err := healthpb.NewHealthClient(cc).Watch(ctx, &healthpb.HealthCheckRequest{Service: "svc"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	cancel()
+	_, err = stream.Recv()
+	require.Error(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(serverLogs.entries()) == 1
+	}, time.Second, 10*time.Millisecond)
+	got := serverLogs.entries()[0]
+	assert.Equal(t, "stream", got["type"])
+	assert.Equal(t, "/grpc.health.v1.Health/Watch", got["method"])
+	assert.Equal(t, "normal", got["event"])
+	assert.Equal(t, "127.0.0.1", got["peer_ip"])
+	assert.Equal(t, "Canceled", got["code"])
+	assert.Equal(t, int64(1), got["sent"])
+	assert.Equal(t, int64(1), got["recv"])
+
+	require.Len(t, clientLogs.entries(), 1)
+	got = clientLogs.entries()[0]
+	assert.Equal(t, "stream", got["type"])
+	assert.Equal(t, cc.Target(), got["target"])
+	assert.Equal(t, "Canceled", got["code"])
+	assert.Equal(t, int64(1), got["sent"])
+	assert.Equal(t, int64(1), got["recv"])
+}
+
+func TestInterceptorBuilder_StreamRecover(t *testing.T) {
+	logs := &recordLogger{}
+	interceptor := NewInterceptorBuilder(logs).BuildServerStreamInterceptor()
+	ss := &fakeServerStream{ctx: context.Background()}
+	err := interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/svc/Panic"}, func(srv any, stream grpc.ServerStream) error {
+		panic("boom")
+	})
+	require.Error(t, err)
+	require.Len(t, logs.entries(), 1)
+	got := logs.entries()[0]
+	assert.Equal(t, "recover", got["event"])
+	assert.Equal(t, "Internal", got["code"])
+	assert.NotEmpty(t, got["stack"])
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func TestInterceptorBuilder_Peer(t *testing.T) {
+	logs := &recordLogger{}
+	interceptor := NewInterceptorBuilder(logs).BuildServerUnaryInterceptor()
+	ctx := pinterceptor.WithPeer(context.Background(), pinterceptor.Peer{App: "order", IP: "10.0.0.1", RequestID: "req-1"})
+	ctx = pinterceptor.WithRequestID(ctx, "req-1")
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Get"}, func(ctx context.Context, req any) (any, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, logs.entries(), 1)
+	got := logs.entries()[0]
+	assert.Equal(t, "order", got["peer"])
+	assert.Equal(t, "10.0.0.1", got["peer_ip"])
+	assert.Equal(t, "req-1", got["request_id"])
+}
+
+func TestInterceptorBuilder_ClientUnary(t *testing.T) {
+	logs := &recordLogger{}
+	cc, err := grpc.NewClient("passthrough:///user", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	interceptor := NewInterceptorBuilder(logs).BuildClientUnaryInterceptor()
+	err = interceptor(context.Background(), "/user.UserService/Get", nil, nil, cc,
+		func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return status.Error(codes.NotFound, "用户不存在")
+		})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	require.Len(t, logs.entries(), 1)
+	got := logs.entries()[0]
+	assert.Equal(t, "unary", got["type"])
+	assert.Equal(t, "/user.UserService/Get", got["method"])
+	assert.Equal(t, "passthrough:///user", got["target"])
+	assert.Equal(t, "NotFound", got["code"])
+	assert.Equal(t, "用户不存在", got["code_msg"])
+	assert.NotContains(t, got, "reason")
+}
+
+func TestInterceptorBuilder_Errx(t *testing.T) {
+	logs := &recordLogger{}
+	interceptor := NewInterceptorBuilder(logs).BuildServerUnaryInterceptor()
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Get"}, func(ctx context.Context, req any) (any, error) {
+		return nil, errx.New(codes.NotFound, "USER_NOT_FOUND", "用户不存在")
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	require.Len(t, logs.entries(), 1)
+	got := logs.entries()[0]
+	assert.Equal(t, "NotFound", got["code"])
+	assert.Equal(t, "用户不存在", got["code_msg"])
+	assert.Equal(t, "USER_NOT_FOUND", got["reason"])
+}
@@ -0,0 +1,42 @@
+package interceptor
+
+import "context"
+
+// 调用方身份在 metadata 中的 key
+const (
+	AppKey       = "app"
+	ClientIPKey  = "client-ip"
+	RequestIDKey = "x-request-id"
+)
+
+// Peer 调用方的身份，由 identity 的服务端拦截器从 metadata 中解析
+type Peer struct {
+	// App 调用方的应用名称
+	App string
+	// IP 调用方的 IP，调用方没有设置时为连接的对端地址
+	IP        string
+	RequestID string
+}
+
+type peerKey struct{}
+
+func WithPeer(ctx context.Context, p Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+func PeerFromContext(ctx context.Context) (Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(Peer)
+	return p, ok
+}
+
+type requestIDKey struct{}
+
+// WithRequestID 设置请求 ID，identity 的客户端拦截器会将其传递给下游
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
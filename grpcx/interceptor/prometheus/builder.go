@@ -6,9 +6,10 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/to404hanga/pkg404/errx"
 	"github.com/to404hanga/pkg404/grpcx/interceptor"
+	"github.com/to404hanga/pkg404/prometheusx"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/status"
 )
 
 type InterceptorBuilder struct {
@@ -17,16 +18,99 @@ type InterceptorBuilder struct {
 	Name       string
 	InstanceId string
 	Help       string
+	// Registerer 注册指标使用的 Registerer，为 nil 时使用 prometheus.DefaultRegisterer
+	Registerer prometheus.Registerer
 	interceptor.Builder
 }
 
 func (b *InterceptorBuilder) BuildServerUnaryInterceptor() grpc.UnaryServerInterceptor {
-	labels := []string{"type", "service", "method", "peer", "code"}
-	vector := prometheus.NewSummaryVec(prometheus.SummaryOpts{
+	vector := b.serverVector()
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		start := time.Now()
+		defer func() {
+			sn, method := b.splitMethodName(info.FullMethod)
+			cost := float64(time.Since(start).Milliseconds())
+			vector.WithLabelValues("unary", sn, method, b.PeerName(ctx), code(err)).Observe(cost)
+		}()
+		resp, err = handler(ctx, req)
+		return
+	}
+}
+
+// BuildServerStreamInterceptor 统计流的持续时间与每个方向上的消息数，
+// 持续时间与 unary 调用共用一个指标，type 为 stream
+func (b *InterceptorBuilder) BuildServerStreamInterceptor() grpc.StreamServerInterceptor {
+	vector := b.serverVector()
+	msgs := b.msgVector(b.Name+"_msg", "peer")
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		start := time.Now()
+		ctx := ss.Context()
+		sn, method := b.splitMethodName(info.FullMethod)
+		peer := b.PeerName(ctx)
+		defer func() {
+			cost := float64(time.Since(start).Milliseconds())
+			vector.WithLabelValues("stream", sn, method, peer, code(err)).Observe(cost)
+		}()
+		return handler(srv, &interceptor.ServerStream{
+			ServerStream: ss,
+			OnSend:       countMsg(msgs.WithLabelValues(sn, method, peer, "sent")),
+			OnRecv:       countMsg(msgs.WithLabelValues(sn, method, peer, "received")),
+		})
+	}
+}
+
+// BuildClientUnaryInterceptor 按下游服务的 target 与方法统计调用耗时
+func (b *InterceptorBuilder) BuildClientUnaryInterceptor() grpc.UnaryClientInterceptor {
+	vector := b.clientVector()
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+		start := time.Now()
+		defer func() {
+			sn, m := b.splitMethodName(method)
+			cost := float64(time.Since(start).Milliseconds())
+			vector.WithLabelValues("unary", sn, m, cc.Target(), code(err)).Observe(cost)
+		}()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// BuildClientStreamInterceptor 统计客户端流的持续时间与每个方向上的消息数
+func (b *InterceptorBuilder) BuildClientStreamInterceptor() grpc.StreamClientInterceptor {
+	vector := b.clientVector()
+	msgs := b.msgVector(b.Name+"_client_msg", "target")
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		sn, m := b.splitMethodName(method)
+		target := cc.Target()
+		finish := func(err error) {
+			cost := float64(time.Since(start).Milliseconds())
+			vector.WithLabelValues("stream", sn, m, target, code(err)).Observe(cost)
+		}
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			finish(err)
+			return nil, err
+		}
+		cs := interceptor.NewClientStream(ctx, s, desc, finish)
+		cs.OnSend = countMsg(msgs.WithLabelValues(sn, m, target, "sent"))
+		cs.OnRecv = countMsg(msgs.WithLabelValues(sn, m, target, "received"))
+		return cs, nil
+	}
+}
+
+func (b *InterceptorBuilder) serverVector() *prometheus.SummaryVec {
+	return b.summaryVector(b.Name, []string{"type", "service", "method", "peer", "code"})
+}
+
+func (b *InterceptorBuilder) clientVector() *prometheus.SummaryVec {
+	return b.summaryVector(b.Name+"_client", []string{"type", "service", "method", "target", "code"})
+}
+
+func (b *InterceptorBuilder) summaryVector(name string, labels []string) *prometheus.SummaryVec {
+	return prometheusx.Register(b.Registerer, prometheus.NewSummaryVec(prometheus.SummaryOpts{
 		Namespace: b.Namespace,
 		Subsystem: b.Subsystem,
 		Help:      b.Help,
-		Name:      b.Name,
+		Name:      name,
 		ConstLabels: map[string]string{
 			"instance_id": b.InstanceId,
 		},
@@ -37,23 +121,20 @@ func (b *InterceptorBuilder) BuildServerUnaryInterceptor() grpc.UnaryServerInter
 			0.99:  0.001,
 			0.999: 0.0001,
 		},
-	}, labels)
-	prometheus.MustRegister(vector)
-	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
-		start := time.Now()
-		defer func() {
-			sn, method := b.splitMethodName(info.FullMethod)
-			code := "OK"
-			if err != nil {
-				st, _ := status.FromError(err)
-				code = st.Code().String()
-			}
-			cost := float64(time.Since(start).Milliseconds())
-			vector.WithLabelValues("unary", sn, method, b.PeerName(ctx), code).Observe(cost)
-		}()
-		resp, err = handler(ctx, req)
-		return
-	}
+	}, labels))
+}
+
+// msgVector 流中收发的消息数，direction 为 sent 或 received
+func (b *InterceptorBuilder) msgVector(name, remote string) *prometheus.CounterVec {
+	return prometheusx.Register(b.Registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: b.Namespace,
+		Subsystem: b.Subsystem,
+		Help:      "流中收发的消息数",
+		Name:      name,
+		ConstLabels: map[string]string{
+			"instance_id": b.InstanceId,
+		},
+	}, []string{"service", "method", remote, "direction"}))
 }
 
 func (b *InterceptorBuilder) splitMethodName(fullMethodName string) (string, string) {
@@ -63,3 +144,17 @@ func (b *InterceptorBuilder) splitMethodName(fullMethodName string) (string, str
 	}
 	return "unknown", "unknown"
 }
+
+// code 与 logger、trace 一致按 errx.Code 解析状态码，context 的错误计为 Canceled 或 DeadlineExceeded
+func code(err error) string {
+	return errx.Code(err).String()
+}
+
+// countMsg 统计成功收发的消息数
+func countMsg(counter prometheus.Counter) func(any, error) {
+	return func(_ any, err error) {
+		if err == nil {
+			counter.Inc()
+		}
+	}
+}
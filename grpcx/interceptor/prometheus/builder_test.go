@@ -0,0 +1,104 @@
+package prometheus
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestInterceptorBuilder_Stream(t *testing.T) {
+	b := &InterceptorBuilder{
+		Namespace:  "pkg404",
+		Subsystem:  "grpcx",
+		Name:       "stream_test",
+		InstanceId: "test",
+		Help:       "stream test",
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	hs := health.NewServer()
+	server := grpc.NewServer(grpc.StreamInterceptor(b.BuildServerStreamInterceptor()))
+	healthpb.RegisterHealthServer(server, hs)
+	go func() {
+		_ = server.Serve(l)
+	}()
+	defer server.Stop()
+	cc, err := grpc.NewClient(l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStreamInterceptor(b.BuildClientStreamInterceptor()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+	stream, err := healthpb.NewHealthClient(cc).Watch(ctx, &healthpb.HealthCheckRequest{Service: "svc"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_NOT_SERVING)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	cancel()
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Canceled, status.Code(err))
+
+	const sn, method = "grpc.health.v1.Health", "Watch"
+	msgs := b.msgVector(b.Name+"_msg", "peer")
+	clientMsgs := b.msgVector(b.Name+"_client_msg", "target")
+	assert.Eventually(t, func() bool {
+		return testutil.CollectAndCount(b.serverVector()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(msgs.WithLabelValues(sn, method, "", "received")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(msgs.WithLabelValues(sn, method, "", "sent")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(clientMsgs.WithLabelValues(sn, method, cc.Target(), "sent")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(clientMsgs.WithLabelValues(sn, method, cc.Target(), "received")))
+	assert.Equal(t, 1, testutil.CollectAndCount(b.clientVector()))
+	// 客户端取消的流按 Canceled 统计
+	_, err = b.clientVector().GetMetricWithLabelValues("stream", sn, method, cc.Target(), "Canceled")
+	require.NoError(t, err)
+	assert.Equal(t, 1, testutil.CollectAndCount(b.clientVector()))
+}
+
+func TestInterceptorBuilder_ClientUnary(t *testing.T) {
+	b := &InterceptorBuilder{
+		Namespace:  "pkg404",
+		Subsystem:  "grpcx",
+		Name:       "client_unary_test",
+		InstanceId: "test",
+		Help:       "client unary test",
+	}
+	cc, err := grpc.NewClient("passthrough:///user", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	interceptor := b.BuildClientUnaryInterceptor()
+	for _, want := range []error{nil, nil, status.Error(codes.NotFound, "")} {
+		err = interceptor(context.Background(), "/user.UserService/Get", nil, nil, cc,
+			func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				return want
+			})
+		assert.Equal(t, want, err)
+	}
+	assert.Equal(t, 2, testutil.CollectAndCount(b.clientVector()))
+	// 按 target、服务与方法统计
+	expected := `
+# HELP pkg404_grpcx_client_unary_test_client client unary test
+# TYPE pkg404_grpcx_client_unary_test_client summary
+pkg404_grpcx_client_unary_test_client_count{code="NotFound",instance_id="test",method="Get",service="user.UserService",target="passthrough:///user",type="unary"} 1
+pkg404_grpcx_client_unary_test_client_count{code="OK",instance_id="test",method="Get",service="user.UserService",target="passthrough:///user",type="unary"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(b.clientVector(), strings.NewReader(expected), "pkg404_grpcx_client_unary_test_client_count"))
+}
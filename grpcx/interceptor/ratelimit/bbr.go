@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+
+	"github.com/to404hanga/pkg404/limiter/bbr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BBRInterceptorBuilder 根据 CPU 使用率与进行中的请求数自适应限流，系统过载时返回 ResourceExhausted
+type BBRInterceptorBuilder struct {
+	limiter *bbr.BBR
+}
+
+// NewBBRInterceptorBuilder 同一个服务的所有拦截器应当共用一个 bbr.BBR
+func NewBBRInterceptorBuilder(limiter *bbr.BBR) *BBRInterceptorBuilder {
+	return &BBRInterceptorBuilder{limiter: limiter}
+}
+
+func (b *BBRInterceptorBuilder) BuildServerUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		done, err := b.limiter.Allow()
+		if err != nil {
+			return nil, status.Error(codes.ResourceExhausted, "过载")
+		}
+		defer done()
+		return handler(ctx, req)
+	}
+}
+
+// BuildServerStreamInterceptor 只在建立流时检查是否过载，
+// 长连接的流不计入进行中的请求，否则会长期占用估算出的并发数，也会拉高估算使用的耗时
+func (b *BBRInterceptorBuilder) BuildServerStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done, err := b.limiter.Allow()
+		if err != nil {
+			return status.Error(codes.ResourceExhausted, "过载")
+		}
+		done()
+		return handler(srv, ss)
+	}
+}
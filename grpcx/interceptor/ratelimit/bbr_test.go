@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/limiter/bbr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBBRInterceptorBuilder(t *testing.T) {
+	limiter := bbr.NewBBR(bbr.WithCPU(func() int64 {
+		return 1000
+	}))
+	b := NewBBRInterceptorBuilder(limiter)
+	unary := b.BuildServerUnaryInterceptor()
+	stream := b.BuildServerStreamInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Get"}
+	streamInfo := &grpc.StreamServerInfo{FullMethod: "/user.UserService/Watch"}
+
+	// 没有历史数据时估算的最大并发数为 1，进行中的请求超过 1 个后开始丢弃
+	_, err := unary(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return unary(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+			return nil, stream(nil, &fakeServerStream{ctx: ctx}, streamInfo, func(srv any, ss grpc.ServerStream) error {
+				t.Fatal("过载时不应建立流")
+				return nil
+			})
+		})
+	})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// 建立后的流不占用并发数
+	err = stream(nil, &fakeServerStream{ctx: context.Background()}, streamInfo, func(srv any, ss grpc.ServerStream) error {
+		_, err := unary(ss.Context(), nil, info, func(ctx context.Context, req any) (any, error) {
+			return unary(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+				return nil, nil
+			})
+		})
+		return err
+	})
+	require.NoError(t, err)
+
+	// 请求结束后恢复
+	_, err = unary(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+}
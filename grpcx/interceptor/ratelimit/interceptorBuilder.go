@@ -4,6 +4,8 @@ import (
 	"context"
 	"strings"
 
+	"github.com/to404hanga/pkg404/downgrade"
+	"github.com/to404hanga/pkg404/grpcx/interceptor"
 	"github.com/to404hanga/pkg404/limiter"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -14,37 +16,108 @@ type InterceptorBuilder struct {
 	limiter          limiter.Limiter
 	key              string
 	fullMethodPrefix string
+	byPeer           bool
+	interceptor.Builder
 }
 
-func NewInterceptorBuilder(limiter limiter.Limiter, key, fullMethodPrefix string) *InterceptorBuilder {
-	return &InterceptorBuilder{
+type Option func(b *InterceptorBuilder)
+
+// WithPeerKey 服务端拦截器按调用方分别限流，限流的 key 为 key:app，调用方没有设置应用名称时使用其 IP
+func WithPeerKey() Option {
+	return func(b *InterceptorBuilder) {
+		b.byPeer = true
+	}
+}
+
+func NewInterceptorBuilder(limiter limiter.Limiter, key, fullMethodPrefix string, opts ...Option) *InterceptorBuilder {
+	b := &InterceptorBuilder{
 		limiter:          limiter,
 		key:              key,
 		fullMethodPrefix: fullMethodPrefix,
 	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
 }
 
+// BuildServerUnaryInterceptor 触发限流时不拒绝请求，而是在 context 中标记降级
 func (b *InterceptorBuilder) BuildServerUnaryInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
-		limited, err := b.limiter.Limit(ctx, b.key)
+		limited, err := b.limiter.Limit(ctx, b.serverKey(ctx))
 		if err != nil || limited {
-			ctx = context.WithValue(ctx, "downgrade", "true")
+			ctx = downgrade.WithDowngrade(ctx)
 		}
 		return handler(ctx, req)
 	}
 }
 
-func (b *InterceptorBuilder) BuildServerStreamInterceptorService() grpc.UnaryServerInterceptor {
+// BuildServerUnaryInterceptorService 只限制 fullMethodPrefix 开头的方法，触发限流时直接拒绝
+func (b *InterceptorBuilder) BuildServerUnaryInterceptorService() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
 		if strings.HasPrefix(info.FullMethod, b.fullMethodPrefix) {
-			limited, err := b.limiter.Limit(ctx, b.key)
-			if err != nil {
-				return nil, status.Errorf(codes.ResourceExhausted, "限流")
-			}
-			if limited {
-				return nil, status.Errorf(codes.ResourceExhausted, "限流")
+			if err := b.limit(ctx, b.serverKey(ctx)); err != nil {
+				return nil, err
 			}
 		}
 		return handler(ctx, req)
 	}
 }
+
+// BuildServerStreamInterceptor 在建立流时判断限流，触发限流时不拒绝，而是在流的 context 中标记降级
+func (b *InterceptorBuilder) BuildServerStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		limited, err := b.limiter.Limit(ss.Context(), b.serverKey(ss.Context()))
+		if err != nil || limited {
+			ss = &interceptor.ServerStream{
+				ServerStream: ss,
+				Ctx:          downgrade.WithDowngrade(ss.Context()),
+			}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// BuildServerStreamInterceptorService 只限制 fullMethodPrefix 开头的方法，在建立流时判断限流，触发限流时直接拒绝
+func (b *InterceptorBuilder) BuildServerStreamInterceptorService() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, b.fullMethodPrefix) {
+			if err := b.limit(ss.Context(), b.serverKey(ss.Context())); err != nil {
+				return err
+			}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// BuildClientStreamInterceptor 在建立流之前判断限流，触发限流时直接拒绝
+func (b *InterceptorBuilder) BuildClientStreamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if strings.HasPrefix(method, b.fullMethodPrefix) {
+			if err := b.limit(ctx, b.key); err != nil {
+				return nil, err
+			}
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func (b *InterceptorBuilder) limit(ctx context.Context, key string) error {
+	limited, err := b.limiter.Limit(ctx, key)
+	if err != nil || limited {
+		return status.Errorf(codes.ResourceExhausted, "限流")
+	}
+	return nil
+}
+
+// serverKey 开启 WithPeerKey 时在 key 后追加调用方的应用名称或 IP
+func (b *InterceptorBuilder) serverKey(ctx context.Context) string {
+	if !b.byPeer {
+		return b.key
+	}
+	peer := b.PeerName(ctx)
+	if peer == "" {
+		peer = b.PeerIP(ctx)
+	}
+	return b.key + ":" + peer
+}
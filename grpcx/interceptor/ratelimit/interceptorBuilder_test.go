@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/to404hanga/pkg404/downgrade"
+	pinterceptor "github.com/to404hanga/pkg404/grpcx/interceptor"
+	limitermocks "github.com/to404hanga/pkg404/limiter/mocks"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func TestInterceptorBuilder_Stream(t *testing.T) {
+	testCases := []struct {
+		name   string
+		method string
+		mock   func(l *limitermocks.MockLimiter)
+		// service 为 true 时使用直接拒绝的拦截器
+		service       bool
+		wantCode      codes.Code
+		wantDowngrade bool
+	}{
+		{
+			name:   "未触发限流",
+			method: "/user.UserService/Watch",
+			mock: func(l *limitermocks.MockLimiter) {
+				l.EXPECT().Limit(gomock.Any(), "key").Return(false, nil)
+			},
+			service:  true,
+			wantCode: codes.OK,
+		},
+		{
+			name:   "触发限流时拒绝",
+			method: "/user.UserService/Watch",
+			mock: func(l *limitermocks.MockLimiter) {
+				l.EXPECT().Limit(gomock.Any(), "key").Return(true, nil)
+			},
+			service:  true,
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name:   "限流器出错时拒绝",
+			method: "/user.UserService/Watch",
+			mock: func(l *limitermocks.MockLimiter) {
+				l.EXPECT().Limit(gomock.Any(), "key").Return(false, errors.New("redis 错误"))
+			},
+			service:  true,
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name:     "其他服务不限流",
+			method:   "/order.OrderService/Watch",
+			mock:     func(l *limitermocks.MockLimiter) {},
+			service:  true,
+			wantCode: codes.OK,
+		},
+		{
+			name:   "触发限流时降级",
+			method: "/user.UserService/Watch",
+			mock: func(l *limitermocks.MockLimiter) {
+				l.EXPECT().Limit(gomock.Any(), "key").Return(true, nil)
+			},
+			wantCode:      codes.OK,
+			wantDowngrade: true,
+		},
+		{
+			name:   "未触发限流时不降级",
+			method: "/user.UserService/Watch",
+			mock: func(l *limitermocks.MockLimiter) {
+				l.EXPECT().Limit(gomock.Any(), "key").Return(false, nil)
+			},
+			wantCode: codes.OK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			l := limitermocks.NewMockLimiter(ctrl)
+			tc.mock(l)
+			b := NewInterceptorBuilder(l, "key", "/user.UserService/")
+			interceptor := b.BuildServerStreamInterceptor()
+			if tc.service {
+				interceptor = b.BuildServerStreamInterceptorService()
+			}
+			var downgraded bool
+			err := interceptor(nil, &fakeServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: tc.method},
+				func(srv any, stream grpc.ServerStream) error {
+					downgraded = downgrade.IsDowngraded(stream.Context())
+					return nil
+				})
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			assert.Equal(t, tc.wantDowngrade, downgraded)
+		})
+	}
+}
+
+func TestInterceptorBuilder_ClientStream(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	l := limitermocks.NewMockLimiter(ctrl)
+	l.EXPECT().Limit(gomock.Any(), "key").Return(true, nil)
+	interceptor := NewInterceptorBuilder(l, "key", "/user.UserService/").BuildClientStreamInterceptor()
+	called := false
+	_, err := interceptor(context.Background(), &grpc.StreamDesc{}, nil, "/user.UserService/Watch",
+		func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			called = true
+			return nil, nil
+		})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.False(t, called)
+}
+
+func TestInterceptorBuilder_PeerKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	l := limitermocks.NewMockLimiter(ctrl)
+	l.EXPECT().Limit(gomock.Any(), "key:order").Return(true, nil)
+	l.EXPECT().Limit(gomock.Any(), "key:10.0.0.1").Return(false, nil)
+
+	interceptor := NewInterceptorBuilder(l, "key", "/user.UserService", WithPeerKey()).BuildServerUnaryInterceptorService()
+	handler := func(ctx context.Context, req any) (any, error) {
+		return nil, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Get"}
+	_, err := interceptor(pinterceptor.WithPeer(context.Background(), pinterceptor.Peer{App: "order", IP: "10.0.0.2"}), nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = interceptor(pinterceptor.WithPeer(context.Background(), pinterceptor.Peer{IP: "10.0.0.1"}), nil, info, handler)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,213 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/to404hanga/pkg404/downgrade"
+	"github.com/to404hanga/pkg404/grpcx/interceptor"
+	"github.com/to404hanga/pkg404/limiter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Action 触发限流后的处理方式
+type Action int
+
+const (
+	// ActionReject 返回 ResourceExhausted
+	ActionReject Action = iota
+	// ActionDowngrade 不拒绝请求，而是在 context 中标记降级
+	ActionDowngrade
+)
+
+// Rule 一条限流规则，请求匹配 Method 后由 Keys 的取值拼接出限流的 key 交给 Limiter 判断
+type Rule struct {
+	// Name 规则名，作为限流 key 的前缀，不同规则共用一个 Limiter 时用于区分
+	Name string
+	// Method 以 / 结尾时按前缀匹配，如 /user.UserService/，否则按完整方法名匹配，为空时匹配所有方法
+	Method string
+	// Keys 组成限流 key 的维度，为空时该规则的所有请求共用一个 key
+	Keys    []KeyFunc
+	Limiter limiter.Limiter
+	Action  Action
+}
+
+func (r Rule) validate() error {
+	if r.Limiter == nil {
+		return errors.New("没有 Limiter")
+	}
+	for _, key := range r.Keys {
+		if key == nil {
+			return errors.New("Keys 中有 nil")
+		}
+	}
+	return nil
+}
+
+func (r Rule) match(method string) bool {
+	if r.Method == "" {
+		return true
+	}
+	if strings.HasSuffix(r.Method, "/") {
+		return strings.HasPrefix(method, r.Method)
+	}
+	return method == r.Method
+}
+
+func (r Rule) key(ctx context.Context, method string, req any) string {
+	var sb strings.Builder
+	sb.WriteString(r.Name)
+	for _, key := range r.Keys {
+		sb.WriteByte(':')
+		sb.WriteString(key(ctx, method, req))
+	}
+	return sb.String()
+}
+
+// KeyFunc 从请求中提取限流 key 的一个维度，取不到时返回空字符串，此时这些请求共用一个 key
+//
+// 流在建立时判断限流，此时 req 为 nil
+type KeyFunc func(ctx context.Context, method string, req any) string
+
+// MethodKey 按方法限流
+func MethodKey() KeyFunc {
+	return func(ctx context.Context, method string, req any) string {
+		return method
+	}
+}
+
+// PeerKey 按调用方的应用名称限流，调用方没有设置应用名称时使用其 IP
+func PeerKey() KeyFunc {
+	var b interceptor.Builder
+	return func(ctx context.Context, method string, req any) string {
+		if app := b.PeerName(ctx); app != "" {
+			return app
+		}
+		return b.PeerIP(ctx)
+	}
+}
+
+// MetadataKey 按请求 metadata 中 key 的值限流
+func MetadataKey(key string) KeyFunc {
+	return func(ctx context.Context, method string, req any) string {
+		md, _ := metadata.FromIncomingContext(ctx)
+		return strings.Join(md.Get(key), ";")
+	}
+}
+
+// FieldKey 按 protobuf 请求中的字段限流，path 为 proto 中的字段名，嵌套的字段以 . 分隔，如 user.id
+func FieldKey(path string) KeyFunc {
+	names := strings.Split(path, ".")
+	return func(ctx context.Context, method string, req any) string {
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return ""
+		}
+		m := msg.ProtoReflect()
+		for i, name := range names {
+			fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+			if fd == nil {
+				return ""
+			}
+			if i == len(names)-1 {
+				return m.Get(fd).String()
+			}
+			if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+				return ""
+			}
+			m = m.Get(fd).Message()
+		}
+		return ""
+	}
+}
+
+// RuleInterceptorBuilder 按规则限流，请求依次经过所有匹配的规则，
+// 任意一条 ActionReject 的规则触发限流时拒绝，ActionDowngrade 的规则触发限流时标记降级
+//
+// 与 InterceptorBuilder 一致，Limiter 出错时视为触发限流
+type RuleInterceptorBuilder struct {
+	rules atomic.Pointer[[]Rule]
+}
+
+// NewRuleInterceptorBuilder 规则没有 Limiter 或者 Keys 中有 nil 时返回错误
+func NewRuleInterceptorBuilder(rules ...Rule) (*RuleInterceptorBuilder, error) {
+	b := &RuleInterceptorBuilder{}
+	if err := b.UpdateRules(rules); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// UpdateRules 在运行时替换全部规则，正在处理的请求仍然使用旧的规则，
+// 有无效的规则时返回错误并继续使用旧的规则
+func (b *RuleInterceptorBuilder) UpdateRules(rules []Rule) error {
+	for i, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("ratelimit: 第 %d 条规则 %s: %w", i, rule.Name, err)
+		}
+	}
+	rules = append([]Rule(nil), rules...)
+	b.rules.Store(&rules)
+	return nil
+}
+
+func (b *RuleInterceptorBuilder) Rules() []Rule {
+	return append([]Rule(nil), *b.rules.Load()...)
+}
+
+func (b *RuleInterceptorBuilder) BuildServerUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		ctx, err = b.limit(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// BuildServerStreamInterceptor 在建立流时判断限流，此时还没有收到请求，FieldKey 的取值为空
+func (b *RuleInterceptorBuilder) BuildServerStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := b.limit(ss.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		if ctx != ss.Context() {
+			ss = &interceptor.ServerStream{
+				ServerStream: ss,
+				Ctx:          ctx,
+			}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// limit 返回的 context 在触发降级时被标记为降级
+func (b *RuleInterceptorBuilder) limit(ctx context.Context, method string, req any) (context.Context, error) {
+	downgraded := false
+	for _, rule := range *b.rules.Load() {
+		if !rule.match(method) {
+			continue
+		}
+		limited, err := rule.Limiter.Limit(ctx, rule.key(ctx, method, req))
+		if err == nil && !limited {
+			continue
+		}
+		if rule.Action == ActionReject {
+			return ctx, status.Errorf(codes.ResourceExhausted, "限流")
+		}
+		downgraded = true
+	}
+	if downgraded {
+		ctx = downgrade.WithDowngrade(ctx)
+	}
+	return ctx, nil
+}
@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/downgrade"
+	pinterceptor "github.com/to404hanga/pkg404/grpcx/interceptor"
+	limitermocks "github.com/to404hanga/pkg404/limiter/mocks"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/sourcecontextpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/typepb"
+)
+
+func TestRuleInterceptorBuilder_Unary(t *testing.T) {
+	testCases := []struct {
+		name          string
+		method        string
+		rules         func(ctrl *gomock.Controller) []Rule
+		wantCode      codes.Code
+		wantDowngrade bool
+	}{
+		{
+			name:   "按方法与调用方拼接 key",
+			method: "/user.UserService/Get",
+			rules: func(ctrl *gomock.Controller) []Rule {
+				l := limitermocks.NewMockLimiter(ctrl)
+				l.EXPECT().Limit(gomock.Any(), "caller:/user.UserService/Get:order").Return(false, nil)
+				return []Rule{{Name: "caller", Keys: []KeyFunc{MethodKey(), PeerKey()}, Limiter: l}}
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name:   "按 metadata 与请求字段拼接 key",
+			method: "/user.UserService/Get",
+			rules: func(ctrl *gomock.Controller) []Rule {
+				l := limitermocks.NewMockLimiter(ctrl)
+				l.EXPECT().Limit(gomock.Any(), "tenant:t1:svc").Return(true, nil)
+				return []Rule{{Name: "tenant", Keys: []KeyFunc{MetadataKey("x-tenant"), FieldKey("service")}, Limiter: l}}
+			},
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name:   "方法不匹配时跳过",
+			method: "/user.UserService/GetAll",
+			rules: func(ctrl *gomock.Controller) []Rule {
+				l := limitermocks.NewMockLimiter(ctrl)
+				return []Rule{
+					{Name: "get", Method: "/user.UserService/Get", Limiter: l},
+					{Name: "order", Method: "/order.OrderService/", Limiter: l},
+				}
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name:   "按服务前缀匹配并降级",
+			method: "/user.UserService/Get",
+			rules: func(ctrl *gomock.Controller) []Rule {
+				l := limitermocks.NewMockLimiter(ctrl)
+				l.EXPECT().Limit(gomock.Any(), "user").Return(true, nil)
+				return []Rule{{Name: "user", Method: "/user.UserService/", Limiter: l, Action: ActionDowngrade}}
+			},
+			wantCode:      codes.OK,
+			wantDowngrade: true,
+		},
+		{
+			name:   "降级之后的拒绝规则仍然生效",
+			method: "/user.UserService/Get",
+			rules: func(ctrl *gomock.Controller) []Rule {
+				l := limitermocks.NewMockLimiter(ctrl)
+				l.EXPECT().Limit(gomock.Any(), "downgrade").Return(true, nil)
+				l.EXPECT().Limit(gomock.Any(), "reject").Return(false, errors.New("redis 错误"))
+				return []Rule{
+					{Name: "downgrade", Limiter: l, Action: ActionDowngrade},
+					{Name: "reject", Limiter: l},
+				}
+			},
+			wantCode: codes.ResourceExhausted,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			b, err := NewRuleInterceptorBuilder(tc.rules(ctrl)...)
+			require.NoError(t, err)
+			interceptor := b.BuildServerUnaryInterceptor()
+			ctx := pinterceptor.WithPeer(context.Background(), pinterceptor.Peer{App: "order"})
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-tenant", "t1"))
+			downgraded := false
+			_, err = interceptor(ctx, &healthpb.HealthCheckRequest{Service: "svc"}, &grpc.UnaryServerInfo{FullMethod: tc.method},
+				func(ctx context.Context, req any) (any, error) {
+					downgraded = downgrade.IsDowngraded(ctx)
+					return nil, nil
+				})
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			assert.Equal(t, tc.wantDowngrade, downgraded)
+		})
+	}
+}
+
+func TestRuleInterceptorBuilder_UpdateRules(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	l := limitermocks.NewMockLimiter(ctrl)
+	l.EXPECT().Limit(gomock.Any(), "old").Return(true, nil)
+	l.EXPECT().Limit(gomock.Any(), "new").Return(false, nil)
+
+	b, err := NewRuleInterceptorBuilder(Rule{Name: "old", Limiter: l})
+	require.NoError(t, err)
+	stream := b.BuildServerStreamInterceptor()
+	handler := func(srv any, ss grpc.ServerStream) error {
+		return nil
+	}
+	ss := &fakeServerStream{ctx: context.Background()}
+	info := &grpc.StreamServerInfo{FullMethod: "/user.UserService/Watch"}
+	assert.Equal(t, codes.ResourceExhausted, status.Code(stream(nil, ss, info, handler)))
+
+	require.NoError(t, b.UpdateRules([]Rule{{Name: "new", Limiter: l}}))
+	require.Len(t, b.Rules(), 1)
+	assert.NoError(t, stream(nil, ss, info, handler))
+
+	// 无效的规则不会替换当前的规则
+	assert.Error(t, b.UpdateRules([]Rule{{Name: "new", Limiter: l}, {Name: "invalid"}}))
+	assert.Error(t, b.UpdateRules([]Rule{{Name: "invalid", Limiter: l, Keys: []KeyFunc{nil}}}))
+	assert.Equal(t, "new", b.Rules()[0].Name)
+	_, err = NewRuleInterceptorBuilder(Rule{Name: "invalid"})
+	assert.Error(t, err)
+}
+
+func TestFieldKey(t *testing.T) {
+	req, err := structpb.NewStruct(map[string]any{"id": 1})
+	require.NoError(t, err)
+	ctx := context.Background()
+	// Struct 的 fields 为 map，无法继续取值
+	assert.Equal(t, "", FieldKey("fields.id")(ctx, "", req))
+	nested := &typepb.Type{SourceContext: &sourcecontextpb.SourceContext{FileName: "user.proto"}}
+	assert.Equal(t, "user.proto", FieldKey("source_context.file_name")(ctx, "", nested))
+	assert.Equal(t, "svc", FieldKey("service")(ctx, "", &healthpb.HealthCheckRequest{Service: "svc"}))
+	assert.Equal(t, "", FieldKey("missing")(ctx, "", &healthpb.HealthCheckRequest{}))
+	assert.Equal(t, "", FieldKey("service")(ctx, "", nil))
+}
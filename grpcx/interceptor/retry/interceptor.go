@@ -0,0 +1,333 @@
+package retry
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/to404hanga/pkg404/gotools/retry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// AttemptsKey 记录在 span 上的实际发出的请求数，包含重试与对冲的请求
+	AttemptsKey = attribute.Key("rpc.grpc.attempts")
+	// HedgedKey 记录在 span 上的是否发出了对冲请求
+	HedgedKey = attribute.Key("rpc.grpc.hedged")
+
+	// latencySamples 计算对冲延迟时保留的最近的耗时数
+	latencySamples = 100
+	// minLatencySamples 耗时数不足时不对冲
+	minLatencySamples = 20
+)
+
+// InterceptorBuilder 客户端重试与对冲，只作用于 WithMethods 指定的幂等方法
+type InterceptorBuilder struct {
+	methods    []string
+	codes      map[codes.Code]struct{}
+	retryOpts  []retry.Option
+	maxTokens  float64
+	tokenRatio float64
+	// percentile 为 0 时不对冲
+	percentile float64
+
+	budgets   sync.Map // target => *budget
+	latencies sync.Map // target + method => *latency
+}
+
+type Option func(b *InterceptorBuilder)
+
+// WithMethods 可以重试的幂等方法，以 / 结尾时按前缀匹配，如 /user.UserService/，否则按完整方法名匹配
+func WithMethods(methods ...string) Option {
+	return func(b *InterceptorBuilder) {
+		b.methods = methods
+	}
+}
+
+// WithCodes 需要重试的错误码，默认只重试 Unavailable
+func WithCodes(cs ...codes.Code) Option {
+	return func(b *InterceptorBuilder) {
+		if len(cs) == 0 {
+			return
+		}
+		b.codes = make(map[codes.Code]struct{}, len(cs))
+		for _, c := range cs {
+			b.codes[c] = struct{}{}
+		}
+	}
+}
+
+// WithRetryOptions 传递给 gotools/retry 的参数，用于配置最大请求次数与退避间隔
+//
+// 拦截器需要等待重试结束才能返回结果，因此 retry.WithAsync 会被忽略
+func WithRetryOptions(opts ...retry.Option) Option {
+	return func(b *InterceptorBuilder) {
+		b.retryOpts = append(append([]retry.Option(nil), opts...), retry.WithAsync(false))
+	}
+}
+
+// WithBudget 每个 target 的重试预算，与 gRPC 的 retryThrottling 一致：
+// 预算初始为 maxTokens，每次可重试的失败减 1，每次成功加 tokenRatio，预算不超过一半时不再重试与对冲
+func WithBudget(maxTokens, tokenRatio float64) Option {
+	return func(b *InterceptorBuilder) {
+		if maxTokens > 0 && tokenRatio > 0 {
+			b.maxTokens = maxTokens
+			b.tokenRatio = tokenRatio
+		}
+	}
+}
+
+// WithHedging 请求耗时超过最近耗时的 percentile 分位数（如 0.95）后，再发出一个相同的请求，以先返回的结果为准
+//
+// 只有响应为 protobuf 消息时才会对冲
+func WithHedging(percentile float64) Option {
+	return func(b *InterceptorBuilder) {
+		if percentile > 0 && percentile < 1 {
+			b.percentile = percentile
+		}
+	}
+}
+
+func NewInterceptorBuilder(opts ...Option) *InterceptorBuilder {
+	b := &InterceptorBuilder{
+		codes:      map[codes.Code]struct{}{codes.Unavailable: {}},
+		maxTokens:  10,
+		tokenRatio: 0.1,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// BuildClientUnaryInterceptor 重试的次数与退避间隔由 gotools/retry 控制，实际发出的请求数记录在 span 的 AttemptsKey 上
+func (b *InterceptorBuilder) BuildClientUnaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !b.idempotent(method) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		bg := b.budget(cc.Target())
+		lat := b.latency(cc.Target() + method)
+		c := &call{
+			method:  method,
+			req:     req,
+			reply:   reply,
+			cc:      cc,
+			invoker: invoker,
+			opts:    opts,
+			budget:  bg,
+			latency: lat,
+		}
+		var lastErr error
+		err := retry.Do(ctx, func() error {
+			if c.attempts > 0 && !bg.allow() {
+				// 预算耗尽，返回最后一次的错误
+				return nil
+			}
+			lastErr = b.attempt(ctx, c)
+			if lastErr == nil || !b.retryable(lastErr) {
+				return nil
+			}
+			return lastErr
+		}, b.retryOpts...)
+		trace.SpanFromContext(ctx).SetAttributes(AttemptsKey.Int(c.attempts), HedgedKey.Bool(c.hedged))
+		if lastErr == nil && err != nil {
+			// 第一次请求之前 ctx 已经结束
+			return status.FromContextError(err).Err()
+		}
+		return lastErr
+	}
+}
+
+// call 一次调用的所有请求共用的参数与计数
+type call struct {
+	method   string
+	req      any
+	reply    any
+	cc       *grpc.ClientConn
+	invoker  grpc.UnaryInvoker
+	opts     []grpc.CallOption
+	budget   *budget
+	latency  *latency
+	attempts int
+	hedged   bool
+}
+
+func (c *call) invoke(ctx context.Context, reply any) error {
+	start := time.Now()
+	err := c.invoker(ctx, c.method, c.req, reply, c.cc, c.opts...)
+	if status.Code(err) != codes.Canceled {
+		c.latency.observe(time.Since(start))
+	}
+	return err
+}
+
+// attempt 发出一个请求，开启对冲时请求耗时超过分位数后再发出一个请求
+func (b *InterceptorBuilder) attempt(ctx context.Context, c *call) error {
+	msg, ok := c.reply.(proto.Message)
+	var delay time.Duration
+	if ok && b.percentile > 0 {
+		delay, ok = c.latency.percentile(b.percentile)
+	}
+	if !ok {
+		c.attempts++
+		err := c.invoke(ctx, c.reply)
+		b.record(c.budget, err)
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		reply proto.Message
+		err   error
+	}
+	// 容量为 2，被取消的请求不会阻塞
+	results := make(chan result, 2)
+	send := func() {
+		c.attempts++
+		reply := msg.ProtoReflect().New().Interface()
+		go func() {
+			err := c.invoke(ctx, reply)
+			results <- result{reply: reply, err: err}
+		}()
+	}
+	send()
+	pending := 1
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var res result
+	select {
+	case res = <-results:
+		pending--
+	case <-timer.C:
+		if c.budget.allow() {
+			c.hedged = true
+			send()
+			pending++
+		}
+		res = <-results
+		pending--
+	}
+	b.record(c.budget, res.err)
+	// 先返回的请求失败时等待另一个请求
+	if res.err != nil && pending > 0 && b.retryable(res.err) {
+		res = <-results
+		pending--
+		b.record(c.budget, res.err)
+	}
+	// 取消并等待未返回的请求结束，避免其 CallOption 在返回之后仍然写入调用方的 Header、Peer 等
+	cancel()
+	for ; pending > 0; pending-- {
+		<-results
+	}
+	if res.err == nil {
+		proto.Reset(msg)
+		proto.Merge(msg, res.reply)
+	}
+	return res.err
+}
+
+func (b *InterceptorBuilder) idempotent(method string) bool {
+	for _, m := range b.methods {
+		if strings.HasSuffix(m, "/") && strings.HasPrefix(method, m) || method == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *InterceptorBuilder) retryable(err error) bool {
+	_, ok := b.codes[status.Code(err)]
+	return ok
+}
+
+// record 成功时增加预算，可重试的失败减少预算
+func (b *InterceptorBuilder) record(bg *budget, err error) {
+	if err == nil {
+		bg.success()
+	} else if b.retryable(err) {
+		bg.failure()
+	}
+}
+
+func (b *InterceptorBuilder) budget(target string) *budget {
+	if val, ok := b.budgets.Load(target); ok {
+		return val.(*budget)
+	}
+	val, _ := b.budgets.LoadOrStore(target, &budget{
+		tokens:    b.maxTokens,
+		maxTokens: b.maxTokens,
+		ratio:     b.tokenRatio,
+	})
+	return val.(*budget)
+}
+
+func (b *InterceptorBuilder) latency(key string) *latency {
+	if val, ok := b.latencies.Load(key); ok {
+		return val.(*latency)
+	}
+	val, _ := b.latencies.LoadOrStore(key, &latency{})
+	return val.(*latency)
+}
+
+type budget struct {
+	lock      sync.Mutex
+	tokens    float64
+	maxTokens float64
+	ratio     float64
+}
+
+func (b *budget) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.tokens > b.maxTokens/2
+}
+
+func (b *budget) success() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens = min(b.tokens+b.ratio, b.maxTokens)
+}
+
+func (b *budget) failure() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens = max(b.tokens-1, 0)
+}
+
+// latency 最近 latencySamples 个请求的耗时
+type latency struct {
+	lock    sync.Mutex
+	samples [latencySamples]time.Duration
+	count   int
+}
+
+func (l *latency) observe(d time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.samples[l.count%latencySamples] = d
+	l.count++
+}
+
+// percentile 耗时数不足 minLatencySamples 时返回 false
+func (l *latency) percentile(p float64) (time.Duration, bool) {
+	l.lock.Lock()
+	n := min(l.count, latencySamples)
+	samples := append([]time.Duration(nil), l.samples[:n]...)
+	l.lock.Unlock()
+	if n < minLatencySamples {
+		return 0, false
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	return samples[int(float64(n-1)*p)], true
+}
@@ -0,0 +1,229 @@
+package retry
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/gotools/retry"
+	"github.com/to404hanga/pkg404/grpcx/interceptor/trace"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestInterceptorBuilder_Retry(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "")
+	testCases := []struct {
+		name         string
+		method       string
+		opts         []Option
+		errs         []error
+		wantCode     codes.Code
+		wantAttempts int
+	}{
+		{
+			name:         "重试直到成功",
+			method:       "/user.UserService/Get",
+			errs:         []error{unavailable, unavailable, nil},
+			wantCode:     codes.OK,
+			wantAttempts: 3,
+		},
+		{
+			name:         "非幂等方法不重试",
+			method:       "/user.UserService/Create",
+			errs:         []error{unavailable, nil},
+			wantCode:     codes.Unavailable,
+			wantAttempts: 1,
+		},
+		{
+			name:         "不可重试的错误码",
+			method:       "/user.UserService/Get",
+			errs:         []error{status.Error(codes.NotFound, ""), nil},
+			wantCode:     codes.NotFound,
+			wantAttempts: 1,
+		},
+		{
+			name:         "自定义错误码",
+			method:       "/user.UserService/Get",
+			opts:         []Option{WithCodes(codes.Aborted)},
+			errs:         []error{status.Error(codes.Aborted, ""), nil},
+			wantCode:     codes.OK,
+			wantAttempts: 2,
+		},
+		{
+			name:         "重试次数耗尽时返回最后一次的错误",
+			method:       "/user.UserService/Get",
+			errs:         []error{unavailable, unavailable, status.Error(codes.Unavailable, "last")},
+			wantCode:     codes.Unavailable,
+			wantAttempts: 3,
+		},
+		{
+			name:         "忽略异步重试",
+			method:       "/user.UserService/Get",
+			opts:         []Option{WithRetryOptions(retry.WithBaseInterval(time.Millisecond), retry.WithAsync(true))},
+			errs:         []error{unavailable, unavailable, nil},
+			wantCode:     codes.OK,
+			wantAttempts: 3,
+		},
+		{
+			name:         "预算耗尽后不再重试",
+			method:       "/user.UserService/Get",
+			opts:         []Option{WithBudget(2, 0.1)},
+			errs:         []error{unavailable, nil},
+			wantCode:     codes.Unavailable,
+			wantAttempts: 1,
+		},
+	}
+	cc, err := grpc.NewClient("passthrough:///user", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			ctx, span := tp.Tracer("test").Start(context.Background(), "call")
+
+			opts := append([]Option{
+				WithMethods("/user.UserService/Get", "/order.OrderService/"),
+				WithRetryOptions(retry.WithBaseInterval(time.Millisecond)),
+			}, tc.opts...)
+			interceptor := NewInterceptorBuilder(opts...).BuildClientUnaryInterceptor()
+			calls := 0
+			err := interceptor(ctx, tc.method, nil, nil, cc,
+				func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+					err := tc.errs[calls]
+					calls++
+					return err
+				})
+			span.End()
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			if tc.wantAttempts == 3 && err != nil {
+				assert.Equal(t, "last", status.Convert(err).Message())
+			}
+			assert.Equal(t, tc.wantAttempts, calls)
+			attrs := recorder.Ended()[0].Attributes()
+			if tc.method == "/user.UserService/Get" {
+				assert.Contains(t, attrs, AttemptsKey.Int(tc.wantAttempts))
+			} else {
+				assert.Empty(t, attrs)
+			}
+		})
+	}
+}
+
+func TestInterceptorBuilder_Hedging(t *testing.T) {
+	cc, err := grpc.NewClient("passthrough:///user", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, span := tp.Tracer("test").Start(context.Background(), "call")
+
+	method := "/grpc.health.v1.Health/Check"
+	b := NewInterceptorBuilder(WithMethods(method), WithHedging(0.9))
+	// 最近的请求耗时都在 10ms 左右
+	for i := 0; i < minLatencySamples; i++ {
+		b.latency(cc.Target() + method).observe(10 * time.Millisecond)
+	}
+
+	var calls atomic.Int32
+	slowCanceled := make(chan struct{})
+	reply := &healthpb.HealthCheckResponse{}
+	err = b.BuildClientUnaryInterceptor()(ctx, method, &healthpb.HealthCheckRequest{}, reply, cc,
+		func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			if calls.Add(1) == 1 {
+				// 第一个请求卡住，直到对冲的请求返回后被取消
+				<-ctx.Done()
+				close(slowCanceled)
+				return status.FromContextError(ctx.Err()).Err()
+			}
+			reply.(*healthpb.HealthCheckResponse).Status = healthpb.HealthCheckResponse_SERVING
+			return nil
+		})
+	span.End()
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, reply.GetStatus())
+	assert.Equal(t, int32(2), calls.Load())
+	select {
+	case <-slowCanceled:
+	case <-time.After(time.Second):
+		t.Fatal("慢请求没有被取消")
+	}
+	assert.Subset(t, recorder.Ended()[0].Attributes(), []attribute.KeyValue{AttemptsKey.Int(2), HedgedKey.Bool(true)})
+}
+
+// slowFirstHealth 每轮第一个请求一直阻塞到被取消，之后的请求立即返回
+type slowFirstHealth struct {
+	healthpb.UnimplementedHealthServer
+	calls atomic.Int32
+}
+
+func (h *slowFirstHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if h.calls.Add(1)%2 == 1 {
+		<-ctx.Done()
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+// TestInterceptorBuilder_HedgingWithTrace 对冲时被取消的请求不能在返回之后写入 trace 传入的 grpc.Peer，需要配合 -race 运行
+func TestInterceptorBuilder_HedgingWithTrace(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	hs := &slowFirstHealth{}
+	healthpb.RegisterHealthServer(server, hs)
+	go func() {
+		_ = server.Serve(l)
+	}()
+	defer server.Stop()
+
+	method := "/grpc.health.v1.Health/Check"
+	b := NewInterceptorBuilder(WithMethods(method), WithHedging(0.9))
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(tracetest.NewSpanRecorder()))
+	cc, err := grpc.NewClient(l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(
+			trace.NewOTELInterceptorBuilder("health", tp.Tracer("test"), nil).BuildUnaryClientInterceptor(),
+			b.BuildClientUnaryInterceptor(),
+		))
+	require.NoError(t, err)
+	defer cc.Close()
+	for i := 0; i < minLatencySamples; i++ {
+		b.latency(cc.Target() + method).observe(5 * time.Millisecond)
+	}
+
+	client := healthpb.NewHealthClient(cc)
+	for i := 0; i < 10; i++ {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+	}
+	assert.Equal(t, int32(20), hs.calls.Load())
+}
+
+func TestLatency_Percentile(t *testing.T) {
+	l := &latency{}
+	for i := 1; i < minLatencySamples; i++ {
+		l.observe(time.Duration(i) * time.Millisecond)
+	}
+	_, ok := l.percentile(0.9)
+	assert.False(t, ok)
+	// 只保留最近的 latencySamples 个耗时
+	for i := 1; i <= 2*latencySamples; i++ {
+		l.observe(time.Duration(i) * time.Millisecond)
+	}
+	d, ok := l.percentile(0.9)
+	require.True(t, ok)
+	assert.Equal(t, 190*time.Millisecond, d)
+}
@@ -0,0 +1,124 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ServerStream 包装 grpc.ServerStream，可以替换 Context，并在每次收发消息后回调
+type ServerStream struct {
+	grpc.ServerStream
+	// Ctx 不为 nil 时替换原有的 Context
+	Ctx context.Context
+	// OnSend 每次 SendMsg 之后调用
+	OnSend func(msg any, err error)
+	// OnRecv 每次 RecvMsg 之后调用，客户端关闭发送时 err 为 io.EOF
+	OnRecv func(msg any, err error)
+}
+
+func (s *ServerStream) Context() context.Context {
+	if s.Ctx != nil {
+		return s.Ctx
+	}
+	return s.ServerStream.Context()
+}
+
+func (s *ServerStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if s.OnSend != nil {
+		s.OnSend(m, err)
+	}
+	return err
+}
+
+func (s *ServerStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if s.OnRecv != nil {
+		s.OnRecv(m, err)
+	}
+	return err
+}
+
+// ClientStream 包装 grpc.ClientStream，在每次收发消息后回调，并在流结束时调用一次 onFinish
+type ClientStream struct {
+	grpc.ClientStream
+	// OnSend 每次 SendMsg 之后调用
+	OnSend func(msg any, err error)
+	// OnRecv 每次 RecvMsg 之后调用，服务端正常结束时 err 为 io.EOF
+	OnRecv func(msg any, err error)
+
+	desc     *grpc.StreamDesc
+	onFinish func(err error)
+	once     sync.Once
+	done     chan struct{}
+}
+
+// NewClientStream ctx 为创建流时使用的 context，onFinish 在流结束时调用，正常结束时 err 为 nil
+//
+// 调用方没有读完流就放弃时，收发消息不会返回错误，因此 ctx 取消或超时时同样视为流结束，
+// err 为对应的 Canceled 或 DeadlineExceeded
+func NewClientStream(ctx context.Context, s grpc.ClientStream, desc *grpc.StreamDesc, onFinish func(err error)) *ClientStream {
+	cs := &ClientStream{ClientStream: s, desc: desc, onFinish: onFinish, done: make(chan struct{})}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-cs.done:
+			case <-ctx.Done():
+				cs.finish(status.FromContextError(ctx.Err()).Err())
+			}
+		}()
+	}
+	return cs
+}
+
+func (s *ClientStream) SendMsg(m any) error {
+	err := s.ClientStream.SendMsg(m)
+	if s.OnSend != nil {
+		s.OnSend(m, err)
+	}
+	// io.EOF 表示流已经结束，真正的错误需要通过 RecvMsg 获取
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *ClientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if s.OnRecv != nil {
+		s.OnRecv(m, err)
+	}
+	switch {
+	case errors.Is(err, io.EOF):
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	case !s.desc.ServerStreams:
+		// 服务端不是流式的，收到唯一的响应后流就结束了
+		s.finish(nil)
+	}
+	return err
+}
+
+func (s *ClientStream) Header() (metadata.MD, error) {
+	md, err := s.ClientStream.Header()
+	if err != nil {
+		s.finish(err)
+	}
+	return md, err
+}
+
+func (s *ClientStream) finish(err error) {
+	s.once.Do(func() {
+		close(s.done)
+		if s.onFinish != nil {
+			s.onFinish(err)
+		}
+	})
+}
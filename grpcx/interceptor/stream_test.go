@@ -0,0 +1,165 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+func (s *fakeServerStream) SendMsg(m any) error      { return nil }
+func (s *fakeServerStream) RecvMsg(m any) error      { return io.EOF }
+
+type fakeClientStream struct {
+	grpc.ClientStream
+	sendErr error
+	recvErr []error
+}
+
+func (s *fakeClientStream) SendMsg(m any) error { return s.sendErr }
+
+func (s *fakeClientStream) RecvMsg(m any) error {
+	if len(s.recvErr) == 0 {
+		return io.EOF
+	}
+	err := s.recvErr[0]
+	s.recvErr = s.recvErr[1:]
+	return err
+}
+
+type ctxKey struct{}
+
+func TestServerStream(t *testing.T) {
+	base := &fakeServerStream{ctx: context.Background()}
+	var sent, recv []error
+	ss := &ServerStream{
+		ServerStream: base,
+		OnSend:       func(_ any, err error) { sent = append(sent, err) },
+		OnRecv:       func(_ any, err error) { recv = append(recv, err) },
+	}
+	assert.Equal(t, base.ctx, ss.Context())
+	ss.Ctx = context.WithValue(base.ctx, ctxKey{}, "val")
+	assert.Equal(t, "val", ss.Context().Value(ctxKey{}))
+
+	assert.NoError(t, ss.SendMsg(nil))
+	assert.ErrorIs(t, ss.RecvMsg(nil), io.EOF)
+	assert.Equal(t, []error{nil}, sent)
+	assert.Equal(t, []error{io.EOF}, recv)
+}
+
+func TestClientStream_Finish(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "")
+	testCases := []struct {
+		name    string
+		desc    *grpc.StreamDesc
+		stream  *fakeClientStream
+		actions func(cs *ClientStream)
+		// finished 为 nil 表示流还没有结束
+		finished []error
+	}{
+		{
+			name:   "服务端流正常结束",
+			desc:   &grpc.StreamDesc{ServerStreams: true},
+			stream: &fakeClientStream{recvErr: []error{nil, nil}},
+			actions: func(cs *ClientStream) {
+				for cs.RecvMsg(nil) == nil {
+				}
+				_ = cs.RecvMsg(nil)
+			},
+			finished: []error{nil},
+		},
+		{
+			name:   "服务端流返回错误",
+			desc:   &grpc.StreamDesc{ServerStreams: true},
+			stream: &fakeClientStream{recvErr: []error{nil, unavailable}},
+			actions: func(cs *ClientStream) {
+				for cs.RecvMsg(nil) == nil {
+				}
+			},
+			finished: []error{unavailable},
+		},
+		{
+			name:   "服务端流未读完",
+			desc:   &grpc.StreamDesc{ServerStreams: true},
+			stream: &fakeClientStream{recvErr: []error{nil, nil}},
+			actions: func(cs *ClientStream) {
+				_ = cs.RecvMsg(nil)
+			},
+		},
+		{
+			name:   "客户端流收到唯一的响应",
+			desc:   &grpc.StreamDesc{ClientStreams: true},
+			stream: &fakeClientStream{recvErr: []error{nil}},
+			actions: func(cs *ClientStream) {
+				_ = cs.SendMsg(nil)
+				_ = cs.RecvMsg(nil)
+			},
+			finished: []error{nil},
+		},
+		{
+			name:   "发送时流已结束",
+			desc:   &grpc.StreamDesc{ClientStreams: true},
+			stream: &fakeClientStream{sendErr: io.EOF, recvErr: []error{unavailable}},
+			actions: func(cs *ClientStream) {
+				if errors.Is(cs.SendMsg(nil), io.EOF) {
+					_ = cs.RecvMsg(nil)
+				}
+			},
+			finished: []error{unavailable},
+		},
+		{
+			name:   "发送失败",
+			desc:   &grpc.StreamDesc{ClientStreams: true},
+			stream: &fakeClientStream{sendErr: unavailable},
+			actions: func(cs *ClientStream) {
+				_ = cs.SendMsg(nil)
+				_ = cs.SendMsg(nil)
+			},
+			finished: []error{unavailable},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var finished []error
+			cs := NewClientStream(context.Background(), tc.stream, tc.desc, func(err error) {
+				finished = append(finished, err)
+			})
+			tc.actions(cs)
+			assert.Equal(t, tc.finished, finished)
+		})
+	}
+}
+
+func TestClientStream_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error, 2)
+	cs := NewClientStream(ctx, &fakeClientStream{recvErr: []error{nil, nil}}, &grpc.StreamDesc{ServerStreams: true}, func(err error) {
+		finished <- err
+	})
+	// 没有读完流就取消
+	require.NoError(t, cs.RecvMsg(nil))
+	cancel()
+	select {
+	case err := <-finished:
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	case <-time.After(time.Second):
+		t.Fatal("取消后没有结束流")
+	}
+	// 已经结束的流不会再次回调
+	_ = cs.RecvMsg(nil)
+	_ = cs.RecvMsg(nil)
+	assert.Empty(t, finished)
+}
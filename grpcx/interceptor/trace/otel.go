@@ -2,8 +2,12 @@ package trace
 
 import (
 	"context"
+	"net"
+	"slices"
+	"strconv"
+	"sync/atomic"
 
-	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/to404hanga/pkg404/errx"
 	"github.com/to404hanga/pkg404/grpcx/interceptor"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -13,21 +17,43 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/proto"
 )
 
+// ErrorReasonKey 记录在 span 上的 errx.Error 的 Reason
+const ErrorReasonKey = attribute.Key("rpc.grpc.error_reason")
+
 type OTELInterceptorBuilder struct {
 	tracer      trace.Tracer
 	propagator  propagation.TextMapPropagator
 	serviceName string
+	// messageEvents 一元调用是否记录收发消息的事件
+	messageEvents bool
 	interceptor.Builder
 }
 
-func NewOTELInterceptorBuilder(serviceName string, tracer trace.Tracer, propagator propagation.TextMapPropagator) *OTELInterceptorBuilder {
-	return &OTELInterceptorBuilder{
+type Option func(b *OTELInterceptorBuilder)
+
+// WithMessageEvents 一元调用也像流式调用一样，为请求与响应各记录一个带有消息大小的事件
+func WithMessageEvents() Option {
+	return func(b *OTELInterceptorBuilder) {
+		b.messageEvents = true
+	}
+}
+
+// NewOTELInterceptorBuilder propagator 为 nil 时使用全局的 propagator，
+// 不论使用哪一个，baggage 都会随 metadata 一起传递
+func NewOTELInterceptorBuilder(serviceName string, tracer trace.Tracer, propagator propagation.TextMapPropagator, opts ...Option) *OTELInterceptorBuilder {
+	b := &OTELInterceptorBuilder{
 		tracer:      tracer,
 		propagator:  propagator,
 		serviceName: serviceName,
 	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
 }
 
 func (b *OTELInterceptorBuilder) BuildUnaryServerInterceptor() grpc.UnaryServerInterceptor {
@@ -35,10 +61,7 @@ func (b *OTELInterceptorBuilder) BuildUnaryServerInterceptor() grpc.UnaryServerI
 	if tracer == nil {
 		tracer = otel.Tracer("github.com/to404hanga/pkg404/grpcx")
 	}
-	propagator := b.propagator
-	if propagator == nil {
-		propagator = otel.GetTextMapPropagator()
-	}
+	propagator := withBaggage(b.propagator)
 	attrs := []attribute.KeyValue{
 		semconv.RPCSystemKey.String("grpc"),
 		attribute.Key("rpc.grpc.kind").String("unary"),
@@ -46,17 +69,24 @@ func (b *OTELInterceptorBuilder) BuildUnaryServerInterceptor() grpc.UnaryServerI
 	}
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
 		ctx = extract(ctx, propagator)
-		ctx, span := tracer.Start(ctx, info.FullMethod, trace.WithAttributes(attrs...), trace.WithSpanKind(trace.SpanKindClient))
+		ctx, span := tracer.Start(ctx, info.FullMethod, trace.WithAttributes(attrs...), trace.WithSpanKind(trace.SpanKindServer))
 		defer span.End()
 		span.SetAttributes(semconv.RPCMethodKey.String(info.FullMethod), semconv.NetPeerNameKey.String(b.PeerName(ctx)), attribute.Key("net.peer.ip").String(b.PeerIP(ctx)))
+		if p, ok := peer.FromContext(ctx); ok {
+			span.SetAttributes(peerAttrs(p.Addr)...)
+		}
+		if b.messageEvents {
+			messageEvent(span, semconv.MessageTypeReceived)(req, nil)
+		}
 		defer func() {
 			if err != nil {
-				span.RecordError(err)
-				if e := errors.FromError(err); e != nil {
-					span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int64(int64(e.Code)))
-				}
-				span.SetStatus(codes.Error, err.Error())
+				recordError(span, err)
+				return
+			}
+			if b.messageEvents {
+				messageEvent(span, semconv.MessageTypeSent)(resp, nil)
 			}
+			span.SetStatus(codes.Ok, "OK")
 		}()
 		return handler(ctx, req)
 	}
@@ -67,10 +97,7 @@ func (b *OTELInterceptorBuilder) BuildUnaryClientInterceptor() grpc.UnaryClientI
 	if tracer == nil {
 		tracer = otel.GetTracerProvider().Tracer("github.com/to404hanga/pkg404/grpcx")
 	}
-	propagator := b.propagator
-	if propagator == nil {
-		propagator = otel.GetTextMapPropagator()
-	}
+	propagator := withBaggage(b.propagator)
 	attrs := []attribute.KeyValue{
 		semconv.RPCSystemKey.String("grpc"),
 		attribute.Key("rpc.grpc.kind").String("unary"),
@@ -80,20 +107,152 @@ func (b *OTELInterceptorBuilder) BuildUnaryClientInterceptor() grpc.UnaryClientI
 		newAttrs := append(attrs, semconv.RPCMethodKey.String(method), semconv.NetPeerNameKey.String(b.serviceName))
 		ctx, span := tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(newAttrs...))
 		ctx = inject(ctx, propagator)
+		if b.messageEvents {
+			messageEvent(span, semconv.MessageTypeSent)(req, nil)
+		}
+		pr := &peer.Peer{}
 		defer func() {
+			span.SetAttributes(peerAttrs(pr.Addr)...)
 			if err != nil {
-				span.RecordError(err)
-				if e := errors.FromError(err); e != nil {
-					span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int64(int64(e.Code)))
-				}
-				span.SetStatus(codes.Error, err.Error())
+				recordError(span, err)
 			} else {
+				if b.messageEvents {
+					messageEvent(span, semconv.MessageTypeReceived)(reply, nil)
+				}
 				span.SetStatus(codes.Ok, "OK")
 			}
 			span.End()
 		}()
-		return invoker(ctx, method, req, reply, cc, opts...)
+		return invoker(ctx, method, req, reply, cc, append(opts, grpc.Peer(pr))...)
+	}
+}
+
+// BuildStreamServerInterceptor 整个流对应一个 span，每条收发的消息记录为一个事件
+func (b *OTELInterceptorBuilder) BuildStreamServerInterceptor() grpc.StreamServerInterceptor {
+	tracer := b.tracer
+	if tracer == nil {
+		tracer = otel.Tracer("github.com/to404hanga/pkg404/grpcx")
+	}
+	propagator := withBaggage(b.propagator)
+	attrs := []attribute.KeyValue{
+		semconv.RPCSystemKey.String("grpc"),
+		attribute.Key("rpc.grpc.kind").String("stream"),
+		attribute.Key("rpc.component").String("server"),
+	}
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		ctx := extract(ss.Context(), propagator)
+		ctx, span := tracer.Start(ctx, info.FullMethod, trace.WithAttributes(attrs...), trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		span.SetAttributes(semconv.RPCMethodKey.String(info.FullMethod), semconv.NetPeerNameKey.String(b.PeerName(ctx)), attribute.Key("net.peer.ip").String(b.PeerIP(ctx)))
+		if p, ok := peer.FromContext(ctx); ok {
+			span.SetAttributes(peerAttrs(p.Addr)...)
+		}
+		defer func() {
+			if err != nil {
+				recordError(span, err)
+			} else {
+				span.SetStatus(codes.Ok, "OK")
+			}
+		}()
+		return handler(srv, &interceptor.ServerStream{
+			ServerStream: ss,
+			Ctx:          ctx,
+			OnSend:       messageEvent(span, semconv.MessageTypeSent),
+			OnRecv:       messageEvent(span, semconv.MessageTypeReceived),
+		})
+	}
+}
+
+// BuildStreamClientInterceptor 整个流对应一个 span，在流结束时结束 span，每条收发的消息记录为一个事件
+func (b *OTELInterceptorBuilder) BuildStreamClientInterceptor() grpc.StreamClientInterceptor {
+	tracer := b.tracer
+	if tracer == nil {
+		tracer = otel.GetTracerProvider().Tracer("github.com/to404hanga/pkg404/grpcx")
+	}
+	propagator := withBaggage(b.propagator)
+	attrs := []attribute.KeyValue{
+		semconv.RPCSystemKey.String("grpc"),
+		attribute.Key("rpc.grpc.kind").String("stream"),
+		attribute.Key("rpc.component").String("client"),
+	}
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		newAttrs := append(attrs, semconv.RPCMethodKey.String(method), semconv.NetPeerNameKey.String(b.serviceName))
+		ctx, span := tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(newAttrs...))
+		ctx = inject(ctx, propagator)
+		finish := func(err error) {
+			if err != nil {
+				recordError(span, err)
+			} else {
+				span.SetStatus(codes.Ok, "OK")
+			}
+			span.End()
+		}
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			finish(err)
+			return nil, err
+		}
+		if p, ok := peer.FromContext(s.Context()); ok {
+			span.SetAttributes(peerAttrs(p.Addr)...)
+		}
+		cs := interceptor.NewClientStream(ctx, s, desc, finish)
+		cs.OnSend = messageEvent(span, semconv.MessageTypeSent)
+		cs.OnRecv = messageEvent(span, semconv.MessageTypeReceived)
+		return cs, nil
+	}
+}
+
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	e := errx.FromError(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int64(int64(e.Code)))
+	if e.Reason != "" {
+		span.SetAttributes(ErrorReasonKey.String(e.Reason))
+	}
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// messageEvent 每条成功收发的消息记录一个事件，同一方向上的 message.id 从 1 开始递增，
+// protobuf 消息还会记录未压缩的大小
+func messageEvent(span trace.Span, typ attribute.KeyValue) func(any, error) {
+	var id atomic.Int64
+	return func(msg any, err error) {
+		if err != nil {
+			return
+		}
+		attrs := []attribute.KeyValue{typ, semconv.MessageIDKey.Int64(id.Add(1))}
+		if m, ok := msg.(proto.Message); ok {
+			attrs = append(attrs, semconv.MessageUncompressedSizeKey.Int(proto.Size(m)))
+		}
+		span.AddEvent("message", trace.WithAttributes(attrs...))
+	}
+}
+
+// peerAttrs 连接的对端地址，与 net.peer.ip 不同，它不会被 metadata 中的 client-ip 覆盖
+func peerAttrs(addr net.Addr) []attribute.KeyValue {
+	if addr == nil {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return []attribute.KeyValue{semconv.NetSockPeerAddrKey.String(addr.String())}
+	}
+	attrs := []attribute.KeyValue{semconv.NetSockPeerAddrKey.String(host)}
+	if p, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, semconv.NetSockPeerPortKey.Int(p))
+	}
+	return attrs
+}
+
+// withBaggage propagator 为 nil 时使用全局的 propagator，不传递 baggage 时组合上 propagation.Baggage
+func withBaggage(propagator propagation.TextMapPropagator) propagation.TextMapPropagator {
+	if propagator == nil {
+		propagator = otel.GetTextMapPropagator()
+	}
+	if slices.Contains(propagator.Fields(), "baggage") {
+		return propagator
 	}
+	return propagation.NewCompositeTextMapPropagator(propagator, propagation.Baggage{})
 }
 
 func extract(ctx context.Context, propagator propagation.TextMapPropagator) context.Context {
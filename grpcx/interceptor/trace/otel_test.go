@@ -0,0 +1,206 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/errx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTELInterceptorBuilder_Stream(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	b := NewOTELInterceptorBuilder("health", tp.Tracer("test"), propagation.TraceContext{})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	hs := health.NewServer()
+	server := grpc.NewServer(grpc.StreamInterceptor(b.BuildStreamServerInterceptor()))
+	healthpb.RegisterHealthServer(server, hs)
+	go func() {
+		_ = server.Serve(l)
+	}()
+	defer server.Stop()
+	cc, err := grpc.NewClient(l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStreamInterceptor(b.BuildStreamClientInterceptor()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+	stream, err := healthpb.NewHealthClient(cc).Watch(ctx, &healthpb.HealthCheckRequest{Service: "svc"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_NOT_SERVING)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	cancel()
+	_, err = stream.Recv()
+	require.Error(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(recorder.Ended()) == 2
+	}, time.Second, 10*time.Millisecond)
+	spans := make(map[trace.SpanKind]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.SpanKind()] = span
+	}
+	clientSpan, serverSpan := spans[trace.SpanKindClient], spans[trace.SpanKindServer]
+	require.NotNil(t, clientSpan)
+	require.NotNil(t, serverSpan)
+	// 服务端的 span 是客户端 span 的子 span
+	assert.Equal(t, clientSpan.SpanContext().TraceID(), serverSpan.Parent().TraceID())
+	assert.Equal(t, clientSpan.SpanContext().SpanID(), serverSpan.Parent().SpanID())
+	assert.Equal(t, codes.Error, clientSpan.Status().Code)
+
+	messages := func(span sdktrace.ReadOnlySpan) []string {
+		var res []string
+		for _, event := range span.Events() {
+			if event.Name != "message" {
+				continue
+			}
+			var typ string
+			var id int64
+			for _, attr := range event.Attributes {
+				switch attr.Key {
+				case semconv.MessageTypeKey:
+					typ = attr.Value.AsString()
+				case semconv.MessageIDKey:
+					id = attr.Value.AsInt64()
+				}
+			}
+			res = append(res, fmt.Sprintf("%s-%d", typ, id))
+		}
+		return res
+	}
+	assert.Equal(t, []string{"SENT-1", "RECEIVED-1", "RECEIVED-2"}, messages(clientSpan))
+	assert.Equal(t, []string{"RECEIVED-1", "SENT-1", "SENT-2"}, messages(serverSpan))
+}
+
+func TestOTELInterceptorBuilder_Unary(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	// 只传入 TraceContext，baggage 依然需要传递
+	b := NewOTELInterceptorBuilder("health", tp.Tracer("test"), propagation.TraceContext{}, WithMessageEvents())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	var serverBaggage baggage.Baggage
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(b.BuildUnaryServerInterceptor(),
+		func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			serverBaggage = baggage.FromContext(ctx)
+			return handler(ctx, req)
+		}))
+	hs := health.NewServer()
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, hs)
+	go func() {
+		_ = server.Serve(l)
+	}()
+	defer server.Stop()
+	cc, err := grpc.NewClient(l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(b.BuildUnaryClientInterceptor()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	member, err := baggage.NewMember("tenant", "t1")
+	require.NoError(t, err)
+	bag, err := baggage.New(member)
+	require.NoError(t, err)
+	ctx := baggage.ContextWithBaggage(context.Background(), bag)
+	req := &healthpb.HealthCheckRequest{Service: "svc"}
+	resp, err := healthpb.NewHealthClient(cc).Check(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "t1", serverBaggage.Member("tenant").Value())
+
+	require.Eventually(t, func() bool {
+		return len(recorder.Ended()) == 2
+	}, time.Second, 10*time.Millisecond)
+	spans := make(map[trace.SpanKind]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.SpanKind()] = span
+	}
+	clientSpan, serverSpan := spans[trace.SpanKindClient], spans[trace.SpanKindServer]
+	require.NotNil(t, clientSpan)
+	require.NotNil(t, serverSpan)
+	assert.Equal(t, clientSpan.SpanContext().SpanID(), serverSpan.Parent().SpanID())
+	assert.Equal(t, codes.Ok, clientSpan.Status().Code)
+	assert.Equal(t, codes.Ok, serverSpan.Status().Code)
+
+	attrs := func(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		res := make(map[attribute.Key]attribute.Value)
+		for _, attr := range span.Attributes() {
+			res[attr.Key] = attr.Value
+		}
+		return res
+	}
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+	clientAttrs := attrs(clientSpan)
+	assert.Equal(t, host, clientAttrs[semconv.NetSockPeerAddrKey].AsString())
+	assert.Equal(t, port, fmt.Sprint(clientAttrs[semconv.NetSockPeerPortKey].AsInt64()))
+	assert.Equal(t, "127.0.0.1", attrs(serverSpan)[semconv.NetSockPeerAddrKey].AsString())
+
+	sizes := func(span sdktrace.ReadOnlySpan) []string {
+		var res []string
+		for _, event := range span.Events() {
+			var typ string
+			var size int64
+			for _, attr := range event.Attributes {
+				switch attr.Key {
+				case semconv.MessageTypeKey:
+					typ = attr.Value.AsString()
+				case semconv.MessageUncompressedSizeKey:
+					size = attr.Value.AsInt64()
+				}
+			}
+			res = append(res, fmt.Sprintf("%s-%d", typ, size))
+		}
+		return res
+	}
+	reqSize, respSize := proto.Size(req), proto.Size(resp)
+	assert.Equal(t, []string{fmt.Sprintf("SENT-%d", reqSize), fmt.Sprintf("RECEIVED-%d", respSize)}, sizes(clientSpan))
+	assert.Equal(t, []string{fmt.Sprintf("RECEIVED-%d", reqSize), fmt.Sprintf("SENT-%d", respSize)}, sizes(serverSpan))
+}
+
+func TestOTELInterceptorBuilder_UnaryServerError(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	b := NewOTELInterceptorBuilder("health", tp.Tracer("test"), nil)
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.UserService/GetUser"}
+	_, err := b.BuildUnaryServerInterceptor()(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, errx.New(grpccodes.NotFound, "USER_NOT_FOUND", "用户不存在")
+	})
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, trace.SpanKindServer, spans[0].SpanKind())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	// 没有消息事件，只有错误事件
+	require.Len(t, spans[0].Events(), 1)
+	assert.Equal(t, "exception", spans[0].Events()[0].Name)
+}
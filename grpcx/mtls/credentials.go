@@ -0,0 +1,53 @@
+package mtls
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// ServerCredentials 用于 grpc.Creds，要求客户端出示由 CA 签发的证书
+func ServerCredentials(r *Reloader) credentials.TransportCredentials {
+	return credentials.NewTLS(r.ServerConfig())
+}
+
+// ClientCredentials 用于 grpc.WithTransportCredentials，serverName 为空时使用连接的 authority 中的主机名或 IP 校验服务端证书
+func ClientCredentials(r *Reloader, serverName string) credentials.TransportCredentials {
+	return &clientCredentials{
+		TransportCredentials: credentials.NewTLS(r.ClientConfig(serverName)),
+		r:                    r,
+		serverName:           serverName,
+	}
+}
+
+// clientCredentials 在握手时才能拿到 authority，按 authority 生成校验名称确定的配置
+type clientCredentials struct {
+	credentials.TransportCredentials
+	r          *Reloader
+	serverName string
+}
+
+func (c *clientCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	name := c.serverName
+	if name == "" {
+		name = authority
+		if host, _, err := net.SplitHostPort(authority); err == nil {
+			name = host
+		}
+	}
+	return credentials.NewTLS(c.r.ClientConfig(name)).ClientHandshake(ctx, authority, conn)
+}
+
+func (c *clientCredentials) Clone() credentials.TransportCredentials {
+	return &clientCredentials{
+		TransportCredentials: c.TransportCredentials.Clone(),
+		r:                    c.r,
+		serverName:           c.serverName,
+	}
+}
+
+func (c *clientCredentials) OverrideServerName(serverName string) error {
+	c.serverName = serverName
+	return c.TransportCredentials.OverrideServerName(serverName)
+}
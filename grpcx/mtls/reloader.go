@@ -0,0 +1,194 @@
+package mtls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/to404hanga/pkg404/logger"
+)
+
+// DefaultInterval 检查证书文件是否变更的默认间隔
+const DefaultInterval = time.Minute
+
+// Reloader 从文件加载证书、私钥与 CA，并定期检查文件内容，变更后重新加载，已经建立的连接不受影响
+//
+// 证书轮换时证书与私钥可能不是同时写入的，加载失败时保留上一次的证书，等待下一次检查
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	interval time.Duration
+	l        logger.Logger
+
+	lock sync.Mutex
+	// raw 上一次成功加载的文件内容，依次为证书、私钥与 CA
+	raw  [3][]byte
+	cert atomic.Pointer[tls.Certificate]
+	pool atomic.Pointer[x509.CertPool]
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type Option func(r *Reloader)
+
+// WithInterval 检查证书文件是否变更的间隔，默认为 DefaultInterval
+func WithInterval(interval time.Duration) Option {
+	return func(r *Reloader) {
+		if interval > 0 {
+			r.interval = interval
+		}
+	}
+}
+
+func WithLogger(l logger.Logger) Option {
+	return func(r *Reloader) {
+		if l != nil {
+			r.l = l
+		}
+	}
+}
+
+// NewReloader certFile 与 keyFile 为 PEM 格式的证书与私钥，caFile 为 PEM 格式的 CA 证书，用于校验对端的证书
+//
+// 首次加载失败时返回错误，不再需要时调用 Close 停止检查
+func NewReloader(certFile, keyFile, caFile string, opts ...Option) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		interval: DefaultInterval,
+		l:        logger.NewNopLogger(),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+func (r *Reloader) watch() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+		if err := r.Reload(); err != nil {
+			r.l.Warn("重新加载证书失败", logger.Error(err))
+		}
+	}
+}
+
+// Reload 文件内容发生变化时重新加载，可以在收到信号等场景下主动调用
+func (r *Reloader) Reload() error {
+	var raw [3][]byte
+	for i, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		raw[i] = data
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if bytes.Equal(raw[0], r.raw[0]) && bytes.Equal(raw[1], r.raw[1]) && bytes.Equal(raw[2], r.raw[2]) {
+		return nil
+	}
+	cert, err := tls.X509KeyPair(raw[0], raw[1])
+	if err != nil {
+		return fmt.Errorf("mtls: 解析证书 %s 失败: %w", r.certFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw[2]) {
+		return fmt.Errorf("mtls: %s 中没有 CA 证书", r.caFile)
+	}
+	r.raw = raw
+	r.cert.Store(&cert)
+	r.pool.Store(pool)
+	r.l.Info("加载证书", logger.String("cert", r.certFile))
+	return nil
+}
+
+// Certificate 当前的证书
+func (r *Reloader) Certificate() *tls.Certificate {
+	return r.cert.Load()
+}
+
+// CAPool 当前的 CA
+func (r *Reloader) CAPool() *x509.CertPool {
+	return r.pool.Load()
+}
+
+// ServerConfig 要求并校验客户端证书，每次握手使用当前的证书与 CA
+func (r *Reloader) ServerConfig() *tls.Config {
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.Certificates = []tls.Certificate{*r.Certificate()}
+		cfg.ClientCAs = r.CAPool()
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		return cfg, nil
+	}
+	return base
+}
+
+// ClientConfig 出示当前的证书，并使用当前的 CA 校验服务端证书的签发者以及 serverName，serverName 可以是域名或 IP
+//
+// tls.Config 的 RootCAs 无法在握手时替换，因此关闭默认的校验，改为在 VerifyConnection 中校验。
+// serverName 为空时只能使用握手中的 SNI，而连接 IP 时不会发送 SNI，此时拒绝握手，
+// 需要按连接的地址校验时使用 ClientCredentials
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.Certificate(), nil
+		},
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			name := serverName
+			if name == "" {
+				name = cs.ServerName
+			}
+			if name == "" {
+				return errors.New("mtls: 没有用于校验服务端证书的名称")
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("mtls: 服务端没有出示证书")
+			}
+			opts := x509.VerifyOptions{
+				Roots:         r.CAPool(),
+				DNSName:       name,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		},
+	}
+}
+
+// Close 停止检查文件，已经创建的配置继续使用最后一次加载的证书
+func (r *Reloader) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+	return nil
+}
@@ -0,0 +1,130 @@
+package mtls
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/grpcx/mtls/mtlstest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// newReloader 在 dir 中写入由 ca 签发的证书并加载
+func newReloader(t *testing.T, dir string, ca *mtlstest.CA, cn string, sans ...string) *Reloader {
+	leaf, err := ca.Issue(cn, sans...)
+	require.NoError(t, err)
+	certFile, keyFile, caFile, err := mtlstest.WriteFiles(dir, cn, leaf, ca)
+	require.NoError(t, err)
+	r, err := NewReloader(certFile, keyFile, caFile, WithInterval(50*time.Millisecond))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = r.Close()
+	})
+	return r
+}
+
+func check(t *testing.T, addr string, creds credentials.TransportCredentials) error {
+	cc, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	require.NoError(t, err)
+	defer cc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestReloader(t *testing.T) {
+	ca, err := mtlstest.NewCA("ca")
+	require.NoError(t, err)
+	serverDir := t.TempDir()
+	sr := newReloader(t, serverDir, ca, "server", "127.0.0.1", "user.svc")
+	cr := newReloader(t, t.TempDir(), ca, "client")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(grpc.Creds(ServerCredentials(sr)))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(l)
+	}()
+	defer server.Stop()
+	addr := l.Addr().String()
+
+	// 默认使用地址校验服务端证书，也可以指定名称
+	assert.NoError(t, check(t, addr, ClientCredentials(cr, "")))
+	assert.NoError(t, check(t, addr, ClientCredentials(cr, "user.svc")))
+	assert.Error(t, check(t, addr, ClientCredentials(cr, "order.svc")))
+
+	// 不出示客户端证书
+	noCert := cr.ClientConfig("")
+	noCert.GetClientCertificate = nil
+	assert.Error(t, check(t, addr, credentials.NewTLS(noCert)))
+
+	// 其他 CA 签发的客户端证书
+	other, err := mtlstest.NewCA("other")
+	require.NoError(t, err)
+	or := newReloader(t, t.TempDir(), other, "client")
+	assert.Error(t, check(t, addr, ClientCredentials(or, "")))
+
+	// 服务端轮换到新的 CA 后，新的连接只接受新 CA 签发的证书
+	leaf, err := other.Issue("server", "127.0.0.1")
+	require.NoError(t, err)
+	old := sr.Certificate()
+	_, _, _, err = mtlstest.WriteFiles(serverDir, "server", leaf, other)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return sr.Certificate() != old
+	}, 2*time.Second, 20*time.Millisecond)
+	assert.Error(t, check(t, addr, ClientCredentials(cr, "")))
+	assert.NoError(t, check(t, addr, ClientCredentials(or, "")))
+}
+
+func TestReloader_ServerName(t *testing.T) {
+	ca, err := mtlstest.NewCA("ca")
+	require.NoError(t, err)
+	// 由同一个 CA 签发，但不包含连接使用的 IP 与名称
+	sr := newReloader(t, t.TempDir(), ca, "server", "10.0.0.1", "order.svc")
+	cr := newReloader(t, t.TempDir(), ca, "client")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(grpc.Creds(ServerCredentials(sr)))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(l)
+	}()
+	defer server.Stop()
+	addr := l.Addr().String()
+
+	assert.Error(t, check(t, addr, ClientCredentials(cr, "")))
+	assert.Error(t, check(t, addr, ClientCredentials(cr, "user.svc")))
+	assert.NoError(t, check(t, addr, ClientCredentials(cr, "order.svc")))
+	// 连接 IP 时没有 SNI，没有指定名称的配置拒绝握手
+	assert.Error(t, check(t, addr, credentials.NewTLS(cr.ClientConfig(""))))
+}
+
+func TestReloader_Invalid(t *testing.T) {
+	ca, err := mtlstest.NewCA("ca")
+	require.NoError(t, err)
+	leaf, err := ca.Issue("server")
+	require.NoError(t, err)
+	other, err := ca.Issue("other")
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	// 证书与私钥不匹配
+	leaf.KeyPEM = other.KeyPEM
+	certFile, keyFile, caFile, err := mtlstest.WriteFiles(dir, "server", leaf, ca)
+	require.NoError(t, err)
+	_, err = NewReloader(certFile, keyFile, caFile)
+	assert.Error(t, err)
+
+	_, err = NewReloader(certFile, keyFile, dir+"/missing.crt")
+	assert.Error(t, err)
+}
@@ -0,0 +1,276 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/to404hanga/pkg404/gotools/retry"
+	"github.com/to404hanga/pkg404/logger"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/naming/endpoints"
+)
+
+const (
+	// DefaultEtcdTTL 租约的默认有效期，单位为秒
+	DefaultEtcdTTL = 10
+	// defaultRetryInterval 租约丢失后重新注册的初始退避间隔
+	defaultRetryInterval = time.Second
+)
+
+// Etcd 基于 etcd 的注册中心，实例以 service/{name}/{addr} 为 key 通过 endpoints.Manager 写入，
+// 并绑定自动续约的租约。租约丢失后按退避策略重新申请租约并注册，直到成功或者实例被摘除
+type Etcd struct {
+	client        *clientv3.Client
+	ttl           int64
+	retryInterval time.Duration
+	l             logger.Logger
+
+	lock     sync.Mutex
+	entries  map[string]*etcdEntry // key => etcdEntry
+	notifies map[string]func(registered bool)
+}
+
+var (
+	_ Registry       = (*Etcd)(nil)
+	_ StatusNotifier = (*Etcd)(nil)
+)
+
+type EtcdOption func(e *Etcd)
+
+// WithEtcdTTL 租约的有效期，单位为秒，默认为 DefaultEtcdTTL
+func WithEtcdTTL(ttl int64) EtcdOption {
+	return func(e *Etcd) {
+		if ttl > 0 {
+			e.ttl = ttl
+		}
+	}
+}
+
+// WithRetryInterval 租约丢失后重新注册的初始退避间隔，默认为一秒
+func WithRetryInterval(interval time.Duration) EtcdOption {
+	return func(e *Etcd) {
+		if interval > 0 {
+			e.retryInterval = interval
+		}
+	}
+}
+
+func WithLogger(l logger.Logger) EtcdOption {
+	return func(e *Etcd) {
+		if l != nil {
+			e.l = l
+		}
+	}
+}
+
+// NewEtcd client 由调用方负责关闭
+func NewEtcd(client *clientv3.Client, opts ...EtcdOption) *Etcd {
+	e := &Etcd{
+		client:        client,
+		ttl:           DefaultEtcdTTL,
+		retryInterval: defaultRetryInterval,
+		l:             logger.NewNopLogger(),
+		entries:       make(map[string]*etcdEntry),
+		notifies:      make(map[string]func(registered bool)),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// etcdEntry 一个已经注册的实例，ins 与 leaseID 由 Etcd 的 lock 保护
+type etcdEntry struct {
+	em      endpoints.Manager
+	key     string
+	ins     Instance
+	leaseID clientv3.LeaseID
+	// cancel 摘除实例时停止续约与重新注册
+	cancel context.CancelFunc
+}
+
+// ServiceKey 服务在 etcd 中的前缀
+func ServiceKey(name string) string {
+	return "service/" + name
+}
+
+func instanceKey(ins Instance) string {
+	return ServiceKey(ins.Name) + "/" + ins.Addr
+}
+
+func (e *Etcd) NotifyStatus(ins Instance, fn func(registered bool)) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.notifies[instanceKey(ins)] = fn
+}
+
+// Register 实例已经注册时复用原有的租约直接覆盖 etcd 中的记录
+func (e *Etcd) Register(ctx context.Context, ins Instance) error {
+	key := instanceKey(ins)
+	e.lock.Lock()
+	if entry, ok := e.entries[key]; ok {
+		defer e.lock.Unlock()
+		// 租约丢失时覆盖会失败，重新注册时会使用新的元数据
+		entry.ins = ins
+		return entry.em.AddEndpoint(ctx, key, toEndpoint(ins), clientv3.WithLease(entry.leaseID))
+	}
+	e.lock.Unlock()
+
+	em, err := endpoints.NewManager(e.client, ServiceKey(ins.Name))
+	if err != nil {
+		return err
+	}
+	kaCtx, cancel := context.WithCancel(context.Background())
+	entry := &etcdEntry{
+		em:     em,
+		key:    key,
+		ins:    ins,
+		cancel: cancel,
+	}
+	ch, err := e.grant(ctx, kaCtx, entry)
+	if err != nil {
+		cancel()
+		return err
+	}
+	e.lock.Lock()
+	e.entries[key] = entry
+	e.lock.Unlock()
+	go e.supervise(kaCtx, entry, ch)
+	return nil
+}
+
+// grant 申请新的租约并写入实例，返回续约的 channel，kaCtx 结束后停止续约
+func (e *Etcd) grant(ctx, kaCtx context.Context, entry *etcdEntry) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	leaseResp, err := e.client.Grant(ctx, e.ttl)
+	if err != nil {
+		return nil, err
+	}
+	// 开启续约
+	ch, err := e.client.KeepAlive(kaCtx, leaseResp.ID)
+	if err == nil {
+		e.lock.Lock()
+		entry.leaseID = leaseResp.ID
+		err = entry.em.AddEndpoint(ctx, entry.key, toEndpoint(entry.ins), clientv3.WithLease(leaseResp.ID))
+		e.lock.Unlock()
+	}
+	if err != nil {
+		// 撤销租约后续约的 channel 随之关闭
+		_, _ = e.client.Revoke(context.WithoutCancel(ctx), leaseResp.ID)
+		return nil, err
+	}
+	return ch, nil
+}
+
+// supervise 续约的 channel 关闭说明租约已经丢失，此时按退避策略重新申请租约并注册，直到成功或者实例被摘除
+func (e *Etcd) supervise(ctx context.Context, entry *etcdEntry, ch <-chan *clientv3.LeaseKeepAliveResponse) {
+	for {
+		for chResp := range ch {
+			e.l.Debug("续约: ", logger.String("resp", chResp.String()))
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		e.notify(entry.key, false)
+		e.l.Warn("租约丢失，重新注册", logger.String("key", entry.key))
+		for {
+			err := retry.Do(ctx, func() error {
+				var err error
+				ch, err = e.grant(ctx, ctx, entry)
+				return err
+			}, retry.WithRetryTimes(5), retry.WithBaseInterval(e.retryInterval), retry.WithBackoffMultiplier(2))
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				break
+			}
+			e.l.Error("重新注册失败", logger.String("key", entry.key), logger.Error(err))
+		}
+		e.l.Info("重新注册成功", logger.String("key", entry.key))
+		e.notify(entry.key, true)
+	}
+}
+
+func (e *Etcd) notify(key string, registered bool) {
+	e.lock.Lock()
+	fn := e.notifies[key]
+	e.lock.Unlock()
+	if fn != nil {
+		fn(registered)
+	}
+}
+
+// Deregister 删除 etcd 中的记录并撤销租约
+func (e *Etcd) Deregister(ctx context.Context, ins Instance) error {
+	key := instanceKey(ins)
+	e.lock.Lock()
+	entry, ok := e.entries[key]
+	delete(e.entries, key)
+	delete(e.notifies, key)
+	e.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	entry.cancel()
+	if err := entry.em.DeleteEndpoint(ctx, key); err != nil {
+		return err
+	}
+	// 租约可能已经过期，撤销失败时等待其自动过期即可
+	_, _ = e.client.Revoke(ctx, entry.leaseID)
+	return nil
+}
+
+func (e *Etcd) Watch(ctx context.Context, name string) (<-chan []Instance, error) {
+	em, err := endpoints.NewManager(e.client, ServiceKey(name))
+	if err != nil {
+		return nil, err
+	}
+	wch, err := em.NewWatchChannel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []Instance, 1)
+	go func() {
+		defer close(ch)
+		all := make(map[string]Instance)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ups, ok := <-wch:
+				if !ok {
+					return
+				}
+				for _, up := range ups {
+					switch up.Op {
+					case endpoints.Add:
+						all[up.Key] = Instance{
+							Name:     name,
+							Addr:     up.Endpoint.Addr,
+							Metadata: toMetadata(up.Endpoint.Metadata),
+						}
+					case endpoints.Delete:
+						delete(all, up.Key)
+					}
+				}
+				push(ch, sorted(all))
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func toEndpoint(ins Instance) endpoints.Endpoint {
+	ep := endpoints.Endpoint{Addr: ins.Addr}
+	if len(ins.Metadata) > 0 {
+		ep.Metadata = ins.Metadata
+	}
+	return ep
+}
+
+// toMetadata etcd 中的元数据以 JSON 存储，反序列化后数字为 float64
+func toMetadata(val any) map[string]any {
+	md, _ := val.(map[string]any)
+	return md
+}
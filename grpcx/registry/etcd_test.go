@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// startEtcd 启动内嵌的 etcd 并返回客户端
+func startEtcd(t *testing.T) *clientv3.Client {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.Name + "=" + peerURL.String()
+	e, err := embed.StartEtcd(cfg)
+	require.NoError(t, err)
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd 启动超时")
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{clientURL.String()},
+		DialTimeout: 3 * time.Second,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = cli.Close()
+	})
+	return cli
+}
+
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func TestEtcd(t *testing.T) {
+	cli := startEtcd(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	reg := NewEtcd(cli, WithRetryInterval(50*time.Millisecond))
+	ins := Instance{Name: "user", Addr: "10.0.0.1:8080", Metadata: map[string]any{"weight": 5}}
+	status := make(chan bool, 10)
+	reg.NotifyStatus(ins, func(registered bool) {
+		status <- registered
+	})
+	ch, err := reg.Watch(ctx, "user")
+	require.NoError(t, err)
+	require.NoError(t, reg.Register(ctx, ins))
+	var list []Instance
+	require.Eventually(t, func() bool {
+		list = next(t, ch)
+		return len(list) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	// etcd 中的数字反序列化后为 float64
+	assert.Equal(t, []Instance{{Name: "user", Addr: "10.0.0.1:8080", Metadata: map[string]any{"weight": float64(5)}}}, list)
+
+	lease := func() clientv3.LeaseID {
+		resp, err := cli.Get(ctx, ServiceKey("user")+"/10.0.0.1:8080")
+		require.NoError(t, err)
+		if len(resp.Kvs) == 0 {
+			return 0
+		}
+		return clientv3.LeaseID(resp.Kvs[0].Lease)
+	}
+
+	// 再次注册复用原有的租约
+	old := lease()
+	require.NotZero(t, old)
+	ins.Metadata = map[string]any{"weight": 20}
+	require.NoError(t, reg.Register(ctx, ins))
+	assert.Equal(t, float64(20), next(t, ch)[0].Metadata["weight"])
+	assert.Equal(t, old, lease())
+
+	// 撤销租约模拟租约过期，实例从 etcd 中消失后自动重新注册
+	_, err = cli.Revoke(ctx, old)
+	require.NoError(t, err)
+	assert.False(t, <-status)
+	assert.True(t, <-status)
+	cur := lease()
+	assert.NotZero(t, cur)
+	assert.NotEqual(t, old, cur)
+
+	require.NoError(t, reg.Deregister(ctx, ins))
+	require.Eventually(t, func() bool {
+		return len(next(t, ch)) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Zero(t, lease())
+}
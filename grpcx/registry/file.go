@@ -0,0 +1,118 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFileInterval 检查文件是否变更的默认间隔
+const DefaultFileInterval = 5 * time.Second
+
+// File 基于静态文件的注册中心，实例列表由配置文件维护，文件格式为 YAML（JSON 同样适用）：
+//
+//	user:
+//	  - addr: 10.0.0.1:8080
+//	    metadata:
+//	      weight: 10
+//
+// 文件变更后会重新加载，Register 与 Deregister 不做任何处理
+type File struct {
+	path     string
+	interval time.Duration
+}
+
+var _ Registry = (*File)(nil)
+
+type FileOption func(f *File)
+
+// WithFileInterval 检查文件是否变更的间隔，默认为 DefaultFileInterval
+func WithFileInterval(interval time.Duration) FileOption {
+	return func(f *File) {
+		if interval > 0 {
+			f.interval = interval
+		}
+	}
+}
+
+func NewFile(path string, opts ...FileOption) *File {
+	f := &File{
+		path:     path,
+		interval: DefaultFileInterval,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Register 实例由文件维护，无需注册
+func (f *File) Register(ctx context.Context, ins Instance) error {
+	return nil
+}
+
+// Deregister 实例由文件维护，无需摘除
+func (f *File) Deregister(ctx context.Context, ins Instance) error {
+	return nil
+}
+
+// Watch 建立时文件无法解析会返回错误，之后的解析错误会被忽略并保留上一次的实例列表
+func (f *File) Watch(ctx context.Context, name string) (<-chan []Instance, error) {
+	stat, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	list, err := f.load(name)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []Instance, 1)
+	push(ch, list)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			cur, err := os.Stat(f.path)
+			if err != nil || (cur.ModTime().Equal(stat.ModTime()) && cur.Size() == stat.Size()) {
+				continue
+			}
+			next, err := f.load(name)
+			if err != nil {
+				continue
+			}
+			stat = cur
+			if !reflect.DeepEqual(next, list) {
+				list = next
+				push(ch, list)
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (f *File) load(name string) ([]Instance, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	var services map[string][]Instance
+	if err = yaml.Unmarshal(data, &services); err != nil {
+		return nil, fmt.Errorf("registry: 解析 %s 失败: %w", f.path, err)
+	}
+	all := make(map[string]Instance, len(services[name]))
+	for _, ins := range services[name] {
+		ins.Name = name
+		all[ins.Addr] = ins
+	}
+	return sorted(all), nil
+}
@@ -0,0 +1,53 @@
+package registry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "services.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+user:
+  - addr: 10.0.0.2:8080
+  - addr: 10.0.0.1:8080
+    metadata:
+      weight: 10
+order:
+  - addr: 10.0.0.3:8080
+`), 0o644))
+	reg := NewFile(path, WithFileInterval(10*time.Millisecond))
+
+	// 静态文件无需注册
+	require.NoError(t, reg.Register(ctx, Instance{Name: "user", Addr: "10.0.0.9:8080"}))
+	ch, err := reg.Watch(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, []Instance{
+		{Name: "user", Addr: "10.0.0.1:8080", Metadata: map[string]any{"weight": 10}},
+		{Name: "user", Addr: "10.0.0.2:8080"},
+	}, next(t, ch))
+
+	// 解析失败时保留上一次的实例列表
+	require.NoError(t, os.WriteFile(path, []byte("user: [}"), 0o644))
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case list := <-ch:
+		t.Fatalf("不应推送 %v", list)
+	default:
+	}
+
+	// JSON 同样适用
+	require.NoError(t, os.WriteFile(path, []byte(`{"user":[{"addr":"10.0.0.4:8080"}]}`), 0o644))
+	assert.Equal(t, []Instance{{Name: "user", Addr: "10.0.0.4:8080"}}, next(t, ch))
+
+	_, err = NewFile(filepath.Join(t.TempDir(), "missing.yaml")).Watch(ctx, "user")
+	assert.Error(t, err)
+}
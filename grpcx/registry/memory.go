@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory 基于内存的注册中心，用于单元测试以及同一进程内的服务发现
+type Memory struct {
+	lock     sync.Mutex
+	services map[string]map[string]Instance // name => addr => Instance
+	watchers map[string]map[chan []Instance]struct{}
+}
+
+var _ Registry = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		services: make(map[string]map[string]Instance),
+		watchers: make(map[string]map[chan []Instance]struct{}),
+	}
+}
+
+func (m *Memory) Register(ctx context.Context, ins Instance) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	all, ok := m.services[ins.Name]
+	if !ok {
+		all = make(map[string]Instance)
+		m.services[ins.Name] = all
+	}
+	all[ins.Addr] = ins
+	m.notify(ins.Name)
+	return nil
+}
+
+func (m *Memory) Deregister(ctx context.Context, ins Instance) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.services[ins.Name][ins.Addr]; !ok {
+		return nil
+	}
+	delete(m.services[ins.Name], ins.Addr)
+	m.notify(ins.Name)
+	return nil
+}
+
+func (m *Memory) Watch(ctx context.Context, name string) (<-chan []Instance, error) {
+	ch := make(chan []Instance, 1)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	ws, ok := m.watchers[name]
+	if !ok {
+		ws = make(map[chan []Instance]struct{})
+		m.watchers[name] = ws
+	}
+	ws[ch] = struct{}{}
+	push(ch, sorted(m.services[name]))
+	go func() {
+		<-ctx.Done()
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		delete(ws, ch)
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// notify 需要持有 lock
+func (m *Memory) notify(name string) {
+	list := sorted(m.services[name])
+	for ch := range m.watchers[name] {
+		push(ch, list)
+	}
+}
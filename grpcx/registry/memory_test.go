@@ -0,0 +1,54 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// next 等待 ch 推送下一个实例列表
+func next(t *testing.T, ch <-chan []Instance) []Instance {
+	select {
+	case list, ok := <-ch:
+		require.True(t, ok, "channel 已关闭")
+		return list
+	case <-time.After(5 * time.Second):
+		t.Fatal("没有收到实例列表")
+		return nil
+	}
+}
+
+func TestMemory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg := NewMemory()
+	require.NoError(t, reg.Register(ctx, Instance{Name: "user", Addr: "b", Metadata: map[string]any{"weight": 1}}))
+
+	ch, err := reg.Watch(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, []Instance{{Name: "user", Addr: "b", Metadata: map[string]any{"weight": 1}}}, next(t, ch))
+
+	require.NoError(t, reg.Register(ctx, Instance{Name: "user", Addr: "a"}))
+	assert.Equal(t, []Instance{
+		{Name: "user", Addr: "a"},
+		{Name: "user", Addr: "b", Metadata: map[string]any{"weight": 1}},
+	}, next(t, ch))
+
+	// 覆盖元数据
+	require.NoError(t, reg.Register(ctx, Instance{Name: "user", Addr: "b", Metadata: map[string]any{"weight": 2}}))
+	assert.Equal(t, map[string]any{"weight": 2}, next(t, ch)[1].Metadata)
+
+	// 其他服务的变更不会推送
+	require.NoError(t, reg.Register(ctx, Instance{Name: "order", Addr: "c"}))
+	require.NoError(t, reg.Deregister(ctx, Instance{Name: "user", Addr: "a"}))
+	assert.Equal(t, []Instance{{Name: "user", Addr: "b", Metadata: map[string]any{"weight": 2}}}, next(t, ch))
+
+	cancel()
+	require.Eventually(t, func() bool {
+		_, ok := <-ch
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
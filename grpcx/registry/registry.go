@@ -0,0 +1,54 @@
+package registry
+
+import (
+	"context"
+	"sort"
+)
+
+// Instance 注册到注册中心的服务实例
+type Instance struct {
+	// Name 服务名
+	Name string `yaml:"-"`
+	Addr string `yaml:"addr"`
+	// Metadata 随实例注册的元数据，如 weight、version、zone、labels 等，供负载均衡与路由使用
+	Metadata map[string]any `yaml:"metadata"`
+}
+
+// Registry 服务注册与发现
+type Registry interface {
+	// Register 注册实例，同一个地址已经注册时覆盖原有的元数据
+	Register(ctx context.Context, ins Instance) error
+	// Deregister 摘除实例
+	Deregister(ctx context.Context, ins Instance) error
+	// Watch 监听名为 name 的服务，建立时与每次变更后推送完整的实例列表，ctx 结束后关闭 channel
+	Watch(ctx context.Context, name string) (<-chan []Instance, error)
+}
+
+// StatusNotifier 注册之后实例可能被动掉线的注册中心实现该接口，如 etcd 的租约过期
+type StatusNotifier interface {
+	// NotifyStatus 在 Register 之前调用，实例掉线以及重新注册成功时调用 fn
+	NotifyStatus(ins Instance, fn func(registered bool))
+}
+
+// push 只保留最新的实例列表，调用方来不及处理时丢弃旧的列表
+//
+// 每个 channel 只能有一个发送方
+func push(ch chan []Instance, list []Instance) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- list
+}
+
+// sorted 按地址排序，保证每次推送的顺序一致
+func sorted(all map[string]Instance) []Instance {
+	list := make([]Instance, 0, len(all))
+	for _, ins := range all {
+		list = append(list, ins)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Addr < list[j].Addr
+	})
+	return list
+}
@@ -0,0 +1,115 @@
+package resolver
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/naming/endpoints"
+	"google.golang.org/grpc/codes"
+	gresolver "google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+// EtcdScheme etcd 解析器的 scheme，target 形如 etcd:///service/user
+const EtcdScheme = "etcd"
+
+type etcdBuilder struct {
+	client *clientv3.Client
+}
+
+// NewEtcdBuilder 基于 etcd 的服务发现，监听 target 路径下由 endpoints.Manager 注册的实例，
+// 实例的元数据会通过 SetMetadata 写入地址
+func NewEtcdBuilder(client *clientv3.Client) gresolver.Builder {
+	return &etcdBuilder{client: client}
+}
+
+func (b *etcdBuilder) Build(target gresolver.Target, cc gresolver.ClientConn, opts gresolver.BuildOptions) (gresolver.Resolver, error) {
+	endpoint := target.URL.Path
+	if endpoint == "" {
+		endpoint = target.URL.Opaque
+	}
+	endpoint = strings.TrimPrefix(endpoint, "/")
+	em, err := endpoints.NewManager(b.client, endpoint)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "resolver: 创建 endpoints.Manager 失败: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	wch, err := em.NewWatchChannel(ctx)
+	if err != nil {
+		cancel()
+		return nil, status.Errorf(codes.Internal, "resolver: 监听 %s 失败: %s", endpoint, err)
+	}
+	r := &etcdResolver{
+		cc:     cc,
+		wch:    wch,
+		cancel: cancel,
+	}
+	r.wg.Add(1)
+	go r.watch(ctx)
+	return r, nil
+}
+
+func (b *etcdBuilder) Scheme() string {
+	return EtcdScheme
+}
+
+type etcdResolver struct {
+	cc     gresolver.ClientConn
+	wch    endpoints.WatchChannel
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (r *etcdResolver) watch(ctx context.Context) {
+	defer r.wg.Done()
+	all := make(map[string]endpoints.Endpoint)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ups, ok := <-r.wch:
+			if !ok {
+				return
+			}
+			for _, up := range ups {
+				switch up.Op {
+				case endpoints.Add:
+					all[up.Key] = up.Endpoint
+				case endpoints.Delete:
+					delete(all, up.Key)
+				}
+			}
+			// 没有可用实例时 balancer 会返回错误并等待下一次更新，这里无需处理
+			_ = r.cc.UpdateState(gresolver.State{Addresses: toAddresses(all)})
+		}
+	}
+}
+
+func toAddresses(eps map[string]endpoints.Endpoint) []gresolver.Address {
+	keys := make([]string, 0, len(eps))
+	for key := range eps {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	addrs := make([]gresolver.Address, 0, len(eps))
+	for _, key := range keys {
+		ep := eps[key]
+		addr := gresolver.Address{Addr: ep.Addr}
+		if md := toMetadata(ep.Metadata); md != nil {
+			addr = SetMetadata(addr, md)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// ResolveNow etcd 的变更通过 watch 推送，无需主动解析
+func (r *etcdResolver) ResolveNow(gresolver.ResolveNowOptions) {}
+
+func (r *etcdResolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
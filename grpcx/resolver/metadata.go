@@ -0,0 +1,62 @@
+package resolver
+
+import (
+	"math"
+	"reflect"
+
+	gresolver "google.golang.org/grpc/resolver"
+)
+
+// Metadata 服务实例注册时携带的元数据，如 weight、version、zone 等
+type Metadata map[string]any
+
+// Equal 实现 attributes 的比较接口，map 本身不可比较
+func (m Metadata) Equal(o any) bool {
+	om, ok := o.(Metadata)
+	return ok && reflect.DeepEqual(m, om)
+}
+
+// DefaultWeight 元数据中没有 weight 或 weight 不大于 0 时使用的权重，所有负载均衡器使用同一个默认值
+const DefaultWeight = 10
+
+type metadataKey struct{}
+
+// SetMetadata 将元数据写入地址的 BalancerAttributes，更新元数据不会导致连接重建
+func SetMetadata(addr gresolver.Address, md Metadata) gresolver.Address {
+	addr.BalancerAttributes = addr.BalancerAttributes.WithValue(metadataKey{}, md)
+	return addr
+}
+
+// MetadataFromAddress 读取 SetMetadata 写入的元数据
+func MetadataFromAddress(addr gresolver.Address) Metadata {
+	md, _ := addr.BalancerAttributes.Value(metadataKey{}).(Metadata)
+	return md
+}
+
+// toMetadata 将 etcd 中反序列化得到的元数据转换为 Metadata
+func toMetadata(val any) Metadata {
+	switch md := val.(type) {
+	case Metadata:
+		return md
+	case map[string]any:
+		return md
+	default:
+		return nil
+	}
+}
+
+// Weight 读取地址元数据中的权重，etcd 中的数字反序列化后为 float64，没有设置或不大于 0 时返回 DefaultWeight
+func Weight(addr gresolver.Address) int {
+	var weight int
+	switch val := MetadataFromAddress(addr)["weight"].(type) {
+	case float64:
+		// 避免过大的浮点数转换为 int 时溢出
+		weight = int(min(val, math.MaxInt32))
+	case int:
+		weight = val
+	}
+	if weight <= 0 {
+		return DefaultWeight
+	}
+	return weight
+}
@@ -0,0 +1,81 @@
+package resolver
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/to404hanga/pkg404/grpcx/registry"
+	"google.golang.org/grpc/codes"
+	gresolver "google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+// RegistryScheme 基于 registry.Registry 的解析器的 scheme，target 形如 registry:///user
+const RegistryScheme = "registry"
+
+type registryBuilder struct {
+	reg registry.Registry
+}
+
+// NewRegistryBuilder 通过 registry.Registry 监听 target 中的服务名，适用于 etcd、内存与静态文件等任意实现，
+// 实例的元数据会通过 SetMetadata 写入地址
+func NewRegistryBuilder(reg registry.Registry) gresolver.Builder {
+	return &registryBuilder{reg: reg}
+}
+
+func (b *registryBuilder) Build(target gresolver.Target, cc gresolver.ClientConn, opts gresolver.BuildOptions) (gresolver.Resolver, error) {
+	name := target.URL.Path
+	if name == "" {
+		name = target.URL.Opaque
+	}
+	name = strings.TrimPrefix(name, "/")
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := b.reg.Watch(ctx, name)
+	if err != nil {
+		cancel()
+		return nil, status.Errorf(codes.Internal, "resolver: 监听 %s 失败: %s", name, err)
+	}
+	r := &registryResolver{
+		cc:     cc,
+		cancel: cancel,
+	}
+	r.wg.Add(1)
+	go r.watch(ch)
+	return r, nil
+}
+
+func (b *registryBuilder) Scheme() string {
+	return RegistryScheme
+}
+
+type registryResolver struct {
+	cc     gresolver.ClientConn
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// watch 注册中心在 ctx 结束后会关闭 channel
+func (r *registryResolver) watch(ch <-chan []registry.Instance) {
+	defer r.wg.Done()
+	for list := range ch {
+		addrs := make([]gresolver.Address, 0, len(list))
+		for _, ins := range list {
+			addr := gresolver.Address{Addr: ins.Addr}
+			if len(ins.Metadata) > 0 {
+				addr = SetMetadata(addr, ins.Metadata)
+			}
+			addrs = append(addrs, addr)
+		}
+		// 没有可用实例时 balancer 会返回错误并等待下一次更新，这里无需处理
+		_ = r.cc.UpdateState(gresolver.State{Addresses: addrs})
+	}
+}
+
+// ResolveNow 变更由注册中心推送，无需主动解析
+func (r *registryResolver) ResolveNow(gresolver.ResolveNowOptions) {}
+
+func (r *registryResolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
@@ -2,88 +2,308 @@ package grpcx
 
 import (
 	"context"
+	"errors"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/to404hanga/pkg404/grpcx/registry"
 	"github.com/to404hanga/pkg404/logger"
 	"github.com/to404hanga/pkg404/netx"
-	clientv3 "go.etcd.io/etcd/client/v3"
-	"go.etcd.io/etcd/client/v3/naming/endpoints"
+	"github.com/to404hanga/pkg404/prometheusx"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// readinessInterval 就绪检查失败后重试的间隔
+	readinessInterval = time.Second
+	// RegistrationHealthService 表示实例是否注册在注册中心的健康检查服务名
+	RegistrationHealthService = "grpcx.registration"
 )
 
 type Server struct {
 	*grpc.Server
-	Port        int
-	EtcdTTL     int64
-	EtcdClient  *clientv3.Client
-	etcdManager endpoints.Manager
-	etcdKey     string
-	cancel      func()
-	Name        string
-	L           logger.Logger
+	Port int
+	// Registry 由调用方负责关闭，Close 时只摘除当前实例
+	Registry   registry.Registry
+	addr       string
+	registered bool
+	// cancel 与 closed 需要持有 mdLock
+	cancel func()
+	closed bool
+	Name   string
+	L      logger.Logger
+
+	// 以下字段作为元数据随实例注册，供负载均衡与路由使用，Weight 为 0 时由负载均衡使用默认权重
+	Weight  int
+	Version string
+	Zone    string
+	Labels  map[string]string
+	mdLock  sync.Mutex
+
+	// ReadinessChecks 全部通过后才会标记为 SERVING 并注册，失败时每隔一秒重试
+	ReadinessChecks []func(ctx context.Context) error
+	// DrainTimeout 关闭时摘除实例并标记为 NOT_SERVING 之后，等待客户端感知的时间，为 0 时不等待
+	DrainTimeout time.Duration
+	// Registerer 注册 grpcx_server_registered 与 grpcx_server_reregister_total 指标，为 nil 时使用 prometheus.DefaultRegisterer
+	Registerer prometheus.Registerer
+	// registeredGauge 与 reregisterCounter 在 Serve 时创建
+	registeredGauge   *prometheus.GaugeVec
+	reregisterCounter *prometheus.CounterVec
+	// ProbeCredentials 就绪检查连接自身使用的凭证，为 nil 时使用明文，
+	// 服务器通过 grpc.Creds 启用 mTLS 时需要设置为客户端的凭证，如 mtls.ClientCredentials
+	ProbeCredentials credentials.TransportCredentials
+	// Health 用于上报健康状态，为 nil 时由 Serve 创建并注册，
+	// 用户自行注册 grpc_health_v1 服务时需要设置为注册的 health.Server
+	Health *health.Server
+	// health 为实际使用的 health.Server，需要持有 mdLock
+	health *health.Server
 }
 
 // Serve 启动服务器并阻塞
+//
+// 服务器开始处理请求且 ReadinessChecks 全部通过后，才会将健康状态标记为 SERVING 并注册到 Registry
 func (s *Server) Serve() error {
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.mdLock.Lock()
+	if s.closed {
+		s.mdLock.Unlock()
+		return grpc.ErrServerStopped
+	}
 	s.cancel = cancel
+	s.mdLock.Unlock()
+	s.initMetrics()
 	port := strconv.Itoa(s.Port)
 	l, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		return err
 	}
 	port = strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
-	err = s.register(ctx, port)
+	if err = s.registerHealth(); err != nil {
+		l.Close()
+		return err
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Server.Serve(l)
+	}()
+	err = s.ready(ctx, "127.0.0.1:"+port)
+	if err == nil {
+		s.setServing(healthpb.HealthCheckResponse_SERVING)
+		err = s.register(ctx, port)
+	}
 	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, grpc.ErrServerStopped) {
+			// Close 在注册之前被调用
+			return <-serveErr
+		}
+		s.Server.Stop()
 		return err
 	}
-	return s.Server.Serve(l)
+	return <-serveErr
 }
 
-func (s *Server) register(ctx context.Context, port string) error {
-	cli := s.EtcdClient
-	serviceName := "service/" + s.Name
-	em, err := endpoints.NewManager(cli, serviceName)
-	if err != nil {
-		return err
+func (s *Server) initMetrics() {
+	s.registeredGauge = prometheusx.Register(s.Registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "grpcx",
+		Subsystem: "server",
+		Name:      "registered",
+		Help:      "实例是否注册在注册中心，1 为已注册",
+	}, []string{"name"}))
+	s.reregisterCounter = prometheusx.Register(s.Registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grpcx",
+		Subsystem: "server",
+		Name:      "reregister_total",
+		Help:      "实例掉线后重新注册的次数",
+	}, []string{"name"}))
+}
+
+// registerHealth 注册 grpc_health_v1 服务，初始状态为 NOT_SERVING
+//
+// 用户已经注册 grpc_health_v1 服务但没有设置 Health 时返回错误，否则健康状态的变化对客户端不可见
+func (s *Server) registerHealth() error {
+	hs := s.Health
+	_, registered := s.Server.GetServiceInfo()[healthpb.Health_ServiceDesc.ServiceName]
+	if hs == nil {
+		if registered {
+			return errors.New("grpcx: grpc_health_v1 服务已经注册，需要通过 Server.Health 传入注册的 health.Server")
+		}
+		hs = health.NewServer()
+	}
+	if !registered {
+		healthpb.RegisterHealthServer(s.Server, hs)
+	}
+	s.mdLock.Lock()
+	s.health = hs
+	s.mdLock.Unlock()
+	s.setServing(healthpb.HealthCheckResponse_NOT_SERVING)
+	hs.SetServingStatus(RegistrationHealthService, healthpb.HealthCheckResponse_NOT_SERVING)
+	return nil
+}
+
+// setServing 设置整体与每个已注册服务的健康状态
+func (s *Server) setServing(st healthpb.HealthCheckResponse_ServingStatus) {
+	s.health.SetServingStatus("", st)
+	for name := range s.Server.GetServiceInfo() {
+		if name != healthpb.Health_ServiceDesc.ServiceName {
+			s.health.SetServingStatus(name, st)
+		}
 	}
-	s.etcdManager = em
-	ip := netx.GetOutboundIP()
-	addr := ip + ":" + port
-	s.etcdKey = serviceName + "/" + addr
-	leaseResp, err := cli.Grant(ctx, s.EtcdTTL)
+}
+
+// ready 等待服务器能够处理请求，并且 ReadinessChecks 全部通过
+func (s *Server) ready(ctx context.Context, addr string) error {
+	creds := s.ProbeCredentials
+	if creds == nil {
+		creds = insecure.NewCredentials()
+	}
+	cc, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
 	if err != nil {
 		return err
 	}
-	// 开启续约
-	ch, err := cli.KeepAlive(ctx, leaseResp.ID)
+	defer cc.Close()
+	client := healthpb.NewHealthClient(cc)
+	check := func(ctx context.Context) error {
+		// 只要能返回结果就说明服务器已经开始处理请求
+		if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+			return err
+		}
+		for _, check := range s.ReadinessChecks {
+			if err := check(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for {
+		err = check(ctx)
+		if err == nil {
+			return nil
+		}
+		s.L.Warn("就绪检查未通过", logger.Error(err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(readinessInterval):
+		}
+	}
+}
+
+func (s *Server) register(ctx context.Context, port string) error {
+	s.mdLock.Lock()
+	if s.closed {
+		// Close 已经开始，不能在摘除之后再注册
+		s.mdLock.Unlock()
+		return grpc.ErrServerStopped
+	}
+	s.addr = netx.GetOutboundIP() + ":" + port
+	ins := s.instance()
+	if n, ok := s.Registry.(registry.StatusNotifier); ok {
+		n.NotifyStatus(ins, func(registered bool) {
+			if registered {
+				s.reregisterCounter.WithLabelValues(s.Name).Inc()
+			}
+			s.setRegistered(registered)
+		})
+	}
+	// 持有 mdLock 避免 UpdateMetadata 与首次注册并发
+	err := s.Registry.Register(ctx, ins)
+	s.registered = err == nil
+	s.mdLock.Unlock()
 	if err != nil {
 		return err
 	}
-	go func() {
-		for chResp := range ch {
-			s.L.Debug("续约: ", logger.String("resp", chResp.String()))
+	s.setRegistered(true)
+	return nil
+}
+
+// setRegistered 通过指标与 RegistrationHealthService 的健康状态暴露注册状态
+func (s *Server) setRegistered(registered bool) {
+	val, st := float64(0), healthpb.HealthCheckResponse_NOT_SERVING
+	if registered {
+		val, st = 1, healthpb.HealthCheckResponse_SERVING
+	}
+	s.registeredGauge.WithLabelValues(s.Name).Set(val)
+	s.mdLock.Lock()
+	hs := s.health
+	s.mdLock.Unlock()
+	if hs != nil {
+		hs.SetServingStatus(RegistrationHealthService, st)
+	}
+}
+
+// UpdateMetadata 更新实例的权重与标签，直接覆盖注册中心中的记录，无需先摘除
+//
+// labels 为 nil 时保留原有的标签
+func (s *Server) UpdateMetadata(ctx context.Context, weight int, labels map[string]string) error {
+	s.mdLock.Lock()
+	defer s.mdLock.Unlock()
+	s.Weight = weight
+	if labels != nil {
+		s.Labels = labels
+	}
+	if !s.registered {
+		// 尚未注册，注册时会使用新的元数据
+		return nil
+	}
+	return s.Registry.Register(ctx, s.instance())
+}
+
+// instance 需要持有 mdLock
+func (s *Server) instance() registry.Instance {
+	md := map[string]any{
+		"weight": s.Weight,
+	}
+	if s.Version != "" {
+		md["version"] = s.Version
+	}
+	if s.Zone != "" {
+		md["zone"] = s.Zone
+	}
+	if len(s.Labels) > 0 {
+		labels := make(map[string]string, len(s.Labels))
+		for k, v := range s.Labels {
+			labels[k] = v
 		}
-	}()
-	return em.AddEndpoint(ctx, s.etcdKey, endpoints.Endpoint{Addr: addr}, clientv3.WithLease(leaseResp.ID))
+		md["labels"] = labels
+	}
+	return registry.Instance{Name: s.Name, Addr: s.addr, Metadata: md}
 }
 
+// Close 先从 Registry 摘除并标记为 NOT_SERVING，等待 DrainTimeout 让客户端感知后再优雅退出
+//
+// 可以在 Serve 之前调用，之后的 Serve 返回 grpc.ErrServerStopped
 func (s *Server) Close() error {
-	s.cancel()
-	if s.etcdManager != nil {
+	s.mdLock.Lock()
+	registered, hs, cancel := s.registered, s.health, s.cancel
+	s.registered = false
+	s.closed = true
+	ins := s.instance()
+	s.mdLock.Unlock()
+	// 先停止就绪检查与注册，避免在摘除之后注册
+	if cancel != nil {
+		cancel()
+	}
+	var err error
+	if registered {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 		defer cancel()
-		err := s.etcdManager.DeleteEndpoint(ctx, s.etcdKey)
-		if err != nil {
-			return err
-		}
+		err = s.Registry.Deregister(ctx, ins)
+		s.registeredGauge.WithLabelValues(s.Name).Set(0)
 	}
-	err := s.EtcdClient.Close()
-	if err != nil {
-		return err
+	if hs != nil {
+		hs.Shutdown()
+	}
+	if s.DrainTimeout > 0 {
+		time.Sleep(s.DrainTimeout)
 	}
 	s.Server.GracefulStop()
-	return nil
+	return err
 }
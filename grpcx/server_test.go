@@ -0,0 +1,363 @@
+package grpcx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/to404hanga/pkg404/grpcx/mtls"
+	"github.com/to404hanga/pkg404/grpcx/mtls/mtlstest"
+	"github.com/to404hanga/pkg404/grpcx/registry"
+	"github.com/to404hanga/pkg404/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// instances 返回 reg 中名为 name 的服务当前的实例列表
+func instances(t *testing.T, reg registry.Registry, name string) []registry.Instance {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := reg.Watch(ctx, name)
+	require.NoError(t, err)
+	return <-ch
+}
+
+func TestServer_UpdateMetadata(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	reg := registry.NewMemory()
+	server := &Server{
+		Server:   grpc.NewServer(),
+		Registry: reg,
+		Name:     "metadata",
+		L:        logger.NewNopLogger(),
+		Weight:   5,
+		Version:  "v1.0.0",
+		Zone:     "cn-hz",
+		Labels:   map[string]string{"env": "canary"},
+	}
+	go func() {
+		_ = server.Serve()
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(instances(t, reg, "metadata")) == 1
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, map[string]any{
+		"weight":  5,
+		"version": "v1.0.0",
+		"zone":    "cn-hz",
+		"labels":  map[string]string{"env": "canary"},
+	}, instances(t, reg, "metadata")[0].Metadata)
+
+	require.NoError(t, server.UpdateMetadata(ctx, 20, nil))
+	got := instances(t, reg, "metadata")
+	require.Len(t, got, 1)
+	assert.Equal(t, 20, got[0].Metadata["weight"])
+	assert.Equal(t, map[string]string{"env": "canary"}, got[0].Metadata["labels"])
+
+	require.NoError(t, server.Close())
+	assert.Empty(t, instances(t, reg, "metadata"))
+}
+
+func TestServer_CloseBeforeServe(t *testing.T) {
+	server := &Server{
+		Server:   grpc.NewServer(),
+		Registry: registry.NewMemory(),
+		Name:     "closed",
+		L:        logger.NewNopLogger(),
+	}
+	require.NoError(t, server.Close())
+	assert.ErrorIs(t, server.Serve(), grpc.ErrServerStopped)
+}
+
+func TestServer_CloseDuringReadiness(t *testing.T) {
+	reg := registry.NewMemory()
+	var server *Server
+	server = &Server{
+		Server:   grpc.NewServer(),
+		Registry: reg,
+		Name:     "closing",
+		L:        logger.NewNopLogger(),
+		ReadinessChecks: []func(ctx context.Context) error{
+			// 忽略 ctx，在 Close 开始之后才通过
+			func(ctx context.Context) error {
+				for {
+					server.mdLock.Lock()
+					closed := server.closed
+					server.mdLock.Unlock()
+					if closed {
+						return nil
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			},
+		},
+		DrainTimeout: 200 * time.Millisecond,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve()
+	}()
+	require.Eventually(t, func() bool {
+		server.mdLock.Lock()
+		defer server.mdLock.Unlock()
+		return server.cancel != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, server.Close())
+	require.NoError(t, <-serveErr)
+	assert.Empty(t, instances(t, reg, "closing"))
+}
+
+func TestServer_Health(t *testing.T) {
+	t.Run("已注册 grpc_health_v1 但未设置 Health", func(t *testing.T) {
+		gs := grpc.NewServer()
+		healthpb.RegisterHealthServer(gs, health.NewServer())
+		server := &Server{
+			Server:   gs,
+			Registry: registry.NewMemory(),
+			Name:     "foreign-health",
+			L:        logger.NewNopLogger(),
+		}
+		assert.Error(t, server.Serve())
+	})
+
+	t.Run("使用用户注册的 Health", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		gs := grpc.NewServer()
+		hs := health.NewServer()
+		healthpb.RegisterHealthServer(gs, hs)
+		port := freePort(t)
+		server := &Server{
+			Server:   gs,
+			Port:     port,
+			Registry: registry.NewMemory(),
+			Name:     "user-health",
+			L:        logger.NewNopLogger(),
+			Health:   hs,
+		}
+		serveErr := make(chan error, 1)
+		go func() {
+			serveErr <- server.Serve()
+		}()
+
+		cc, err := grpc.NewClient("127.0.0.1:"+strconv.Itoa(port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+		defer cc.Close()
+		client := healthpb.NewHealthClient(cc)
+		require.Eventually(t, func() bool {
+			resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: RegistrationHealthService})
+			return err == nil && resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+		}, 5*time.Second, 20*time.Millisecond)
+
+		require.NoError(t, server.Close())
+		require.NoError(t, <-serveErr)
+	})
+}
+
+func TestServer_Readiness(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var ready atomic.Bool
+	port := freePort(t)
+	reg := registry.NewMemory()
+	server := &Server{
+		Server:   grpc.NewServer(),
+		Port:     port,
+		Registry: reg,
+		Name:     "readiness",
+		L:        logger.NewNopLogger(),
+		ReadinessChecks: []func(ctx context.Context) error{
+			func(ctx context.Context) error {
+				if !ready.Load() {
+					return errors.New("数据库未连接")
+				}
+				return nil
+			},
+		},
+		DrainTimeout: 500 * time.Millisecond,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve()
+	}()
+
+	cc, err := grpc.NewClient("127.0.0.1:"+strconv.Itoa(port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	client := healthpb.NewHealthClient(cc)
+	status := func() healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			return healthpb.HealthCheckResponse_UNKNOWN
+		}
+		return resp.GetStatus()
+	}
+	registered := func() bool {
+		return len(instances(t, reg, "readiness")) == 1
+	}
+
+	// 就绪检查未通过时不注册，健康状态为 NOT_SERVING
+	require.Eventually(t, func() bool {
+		return status() == healthpb.HealthCheckResponse_NOT_SERVING
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.False(t, registered())
+
+	ready.Store(true)
+	require.Eventually(t, registered, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, status())
+
+	// 关闭时先摘除并标记为 NOT_SERVING，等待 DrainTimeout 期间仍然可以处理请求
+	closed := make(chan error, 1)
+	go func() {
+		closed <- server.Close()
+	}()
+	require.Eventually(t, func() bool {
+		return status() == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 10*time.Millisecond)
+	assert.False(t, registered())
+	select {
+	case <-closed:
+		t.Fatal("DrainTimeout 结束之前不应退出")
+	default:
+	}
+	require.NoError(t, <-closed)
+	require.NoError(t, <-serveErr)
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// notifierRegistry 保存 Server 传入的回调，用于模拟实例掉线与重新注册
+type notifierRegistry struct {
+	*registry.Memory
+	lock sync.Mutex
+	fn   func(registered bool)
+}
+
+func (r *notifierRegistry) NotifyStatus(ins registry.Instance, fn func(registered bool)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.fn = fn
+}
+
+func (r *notifierRegistry) notify(registered bool) {
+	r.lock.Lock()
+	fn := r.fn
+	r.lock.Unlock()
+	fn(registered)
+}
+
+func TestServer_RegistrationStatus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	port := freePort(t)
+	reg := &notifierRegistry{Memory: registry.NewMemory()}
+	server := &Server{
+		Server:     grpc.NewServer(),
+		Port:       port,
+		Registry:   reg,
+		Name:       "recovery",
+		L:          logger.NewNopLogger(),
+		Registerer: prometheus.NewRegistry(),
+	}
+	go func() {
+		_ = server.Serve()
+	}()
+	defer server.Close()
+
+	cc, err := grpc.NewClient("127.0.0.1:"+strconv.Itoa(port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	client := healthpb.NewHealthClient(cc)
+	registration := func() healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: RegistrationHealthService})
+		if err != nil {
+			return healthpb.HealthCheckResponse_UNKNOWN
+		}
+		return resp.GetStatus()
+	}
+
+	require.Eventually(t, func() bool {
+		return registration() == healthpb.HealthCheckResponse_SERVING
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(server.registeredGauge.WithLabelValues("recovery")))
+	before := testutil.ToFloat64(server.reregisterCounter.WithLabelValues("recovery"))
+
+	// 实例掉线
+	reg.notify(false)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, registration())
+	assert.Equal(t, float64(0), testutil.ToFloat64(server.registeredGauge.WithLabelValues("recovery")))
+
+	// 重新注册成功
+	reg.notify(true)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, registration())
+	assert.Equal(t, float64(1), testutil.ToFloat64(server.registeredGauge.WithLabelValues("recovery")))
+	assert.Equal(t, before+1, testutil.ToFloat64(server.reregisterCounter.WithLabelValues("recovery")))
+}
+
+func TestServer_MTLS(t *testing.T) {
+	ca, err := mtlstest.NewCA("ca")
+	require.NoError(t, err)
+	load := func(cn string, sans ...string) *mtls.Reloader {
+		leaf, err := ca.Issue(cn, sans...)
+		require.NoError(t, err)
+		certFile, keyFile, caFile, err := mtlstest.WriteFiles(t.TempDir(), cn, leaf, ca)
+		require.NoError(t, err)
+		r, err := mtls.NewReloader(certFile, keyFile, caFile)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = r.Close()
+		})
+		return r
+	}
+	// 客户端通过服务名校验服务端证书，就绪检查通过 127.0.0.1 校验
+	sr := load("mtls", "mtls", "127.0.0.1")
+
+	reg := registry.NewMemory()
+	server := &Server{
+		Server:   grpc.NewServer(grpc.Creds(mtls.ServerCredentials(sr))),
+		Registry: reg,
+		Name:     "mtls",
+		L:        logger.NewNopLogger(),
+		// 就绪检查使用服务端自身的证书连接
+		ProbeCredentials: mtls.ClientCredentials(sr, ""),
+	}
+	go func() {
+		_ = server.Serve()
+	}()
+	defer server.Close()
+
+	require.Eventually(t, func() bool {
+		return len(instances(t, reg, "mtls")) == 1
+	}, 5*time.Second, 20*time.Millisecond)
+
+	cc, err := NewClientConn("mtls", reg, grpc.WithTransportCredentials(mtls.ClientCredentials(load("client"), "mtls")))
+	require.NoError(t, err)
+	defer cc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+}
@@ -0,0 +1,154 @@
+package bbr
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLimitExceed 系统过载，请求被丢弃
+var ErrLimitExceed = errors.New("bbr: 系统过载")
+
+const (
+	// DefaultWindow 统计最大通过数与最小耗时的窗口
+	DefaultWindow = 10 * time.Second
+	// DefaultBuckets 窗口内的桶数
+	DefaultBuckets = 100
+	// DefaultCPUThreshold CPU 使用率超过千分之八百时开始限流
+	DefaultCPUThreshold = 800
+	// coolDown 触发限流后即使 CPU 恢复也继续按并发数限流的时间，避免 CPU 抖动导致反复放开
+	coolDown = time.Second
+)
+
+// BBR 参考 TCP BBR 的自适应限流
+//
+// CPU 使用率超过阈值时，根据窗口内每个桶的最大通过数与最小耗时估算系统的最大并发数，
+// 进行中的请求数超过最大并发数时丢弃请求。它与 limiter.Limiter 不同，请求结束后需要调用 Allow 返回的函数
+type BBR struct {
+	window       time.Duration
+	buckets      int
+	cpuThreshold int64
+	cpu          func() int64
+	now          func() time.Time
+
+	pass *window
+	rt   *window
+
+	inflight atomic.Int64
+	// prevDrop 上一次开始限流的时间，0 表示当前没有限流
+	prevDrop atomic.Int64
+}
+
+type Option func(b *BBR)
+
+// WithWindow 统计的窗口与窗口内的桶数，默认为 DefaultWindow 与 DefaultBuckets
+func WithWindow(window time.Duration, buckets int) Option {
+	return func(b *BBR) {
+		if window > 0 && buckets > 0 {
+			b.window = window
+			b.buckets = buckets
+		}
+	}
+}
+
+// WithCPUThreshold CPU 使用率的阈值，单位为千分之一，默认为 DefaultCPUThreshold
+func WithCPUThreshold(threshold int64) Option {
+	return func(b *BBR) {
+		b.cpuThreshold = threshold
+	}
+}
+
+// WithCPU 自定义 CPU 使用率的来源，单位为千分之一，默认为 CPUUsage
+func WithCPU(cpu func() int64) Option {
+	return func(b *BBR) {
+		if cpu != nil {
+			b.cpu = cpu
+		}
+	}
+}
+
+func NewBBR(opts ...Option) *BBR {
+	b := &BBR{
+		window:       DefaultWindow,
+		buckets:      DefaultBuckets,
+		cpuThreshold: DefaultCPUThreshold,
+		cpu:          CPUUsage,
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	duration := b.window / time.Duration(b.buckets)
+	b.pass = newWindow(b.buckets, duration)
+	b.rt = newWindow(b.buckets, duration)
+	return b
+}
+
+// Allow 系统过载时返回 ErrLimitExceed，否则返回请求结束时需要调用的函数
+func (b *BBR) Allow() (func(), error) {
+	if b.shouldDrop() {
+		return nil, ErrLimitExceed
+	}
+	b.inflight.Add(1)
+	start := b.now()
+	return func() {
+		now := b.now()
+		b.rt.add(now, now.Sub(start).Milliseconds())
+		b.inflight.Add(-1)
+		b.pass.add(now, 1)
+	}, nil
+}
+
+func (b *BBR) shouldDrop() bool {
+	now := b.now()
+	if b.cpu() < b.cpuThreshold {
+		prevDrop := b.prevDrop.Load()
+		if prevDrop == 0 {
+			return false
+		}
+		if now.Sub(time.Unix(0, prevDrop)) <= coolDown {
+			return b.overloaded(now)
+		}
+		b.prevDrop.Store(0)
+		return false
+	}
+	if !b.overloaded(now) {
+		return false
+	}
+	b.prevDrop.CompareAndSwap(0, now.UnixNano())
+	return true
+}
+
+// overloaded 进行中的请求数是否超过估算的最大并发数
+func (b *BBR) overloaded(now time.Time) bool {
+	inflight := b.inflight.Load()
+	return inflight > 1 && inflight > b.maxInflight(now)
+}
+
+// maxInflight 最大通过数 * 最小耗时 即为系统在最佳状态下能够同时处理的请求数
+func (b *BBR) maxInflight(now time.Time) int64 {
+	bucketsPerSecond := float64(time.Second) / float64(b.window/time.Duration(b.buckets))
+	return int64(math.Floor(float64(b.maxPass(now)*b.minRT(now))*bucketsPerSecond/1000 + 0.5))
+}
+
+// maxPass 窗口内单个桶的最大通过数，没有数据时为 1
+func (b *BBR) maxPass(now time.Time) int64 {
+	var res int64 = 1
+	b.pass.reduce(now, func(bk bucket) {
+		res = max(res, bk.sum)
+	})
+	return res
+}
+
+// minRT 窗口内单个桶的最小平均耗时，单位为毫秒，没有数据时为 1
+func (b *BBR) minRT(now time.Time) int64 {
+	res := math.MaxFloat64
+	b.rt.reduce(now, func(bk bucket) {
+		res = min(res, math.Ceil(float64(bk.sum)/float64(bk.count)))
+	})
+	if res == math.MaxFloat64 || res < 1 {
+		return 1
+	}
+	return int64(res)
+}
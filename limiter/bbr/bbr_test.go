@@ -0,0 +1,96 @@
+package bbr
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBBR(t *testing.T) {
+	var cpu atomic.Int64
+	now := time.Unix(1000, 0)
+	b := NewBBR(WithWindow(time.Second, 10), WithCPUThreshold(800), WithCPU(cpu.Load))
+	b.now = func() time.Time {
+		return now
+	}
+	allow := func(n int) []func() {
+		var dones []func()
+		for i := 0; i < n; i++ {
+			done, err := b.Allow()
+			if err != nil {
+				break
+			}
+			dones = append(dones, done)
+		}
+		return dones
+	}
+
+	// 每个桶通过 10 个请求，每个请求耗时 100ms，估算的最大并发数为 10 * 100 * 10 / 1000 = 10
+	for i := 0; i < 5; i++ {
+		dones := allow(10)
+		require.Len(t, dones, 10)
+		now = now.Add(100 * time.Millisecond)
+		for _, done := range dones {
+			done()
+		}
+	}
+	assert.Equal(t, int64(10), b.maxPass(now))
+	assert.Equal(t, int64(100), b.minRT(now))
+	assert.Equal(t, int64(10), b.maxInflight(now))
+
+	// CPU 没有超过阈值时不限流
+	dones := allow(20)
+	assert.Len(t, dones, 20)
+	for _, done := range dones {
+		done()
+	}
+
+	// CPU 超过阈值后，进行中的请求数超过最大并发数时丢弃
+	cpu.Store(900)
+	dones = allow(20)
+	assert.Len(t, dones, 11)
+	_, err := b.Allow()
+	assert.ErrorIs(t, err, ErrLimitExceed)
+
+	// 冷却期内 CPU 恢复也继续按并发数限流
+	cpu.Store(100)
+	now = now.Add(500 * time.Millisecond)
+	_, err = b.Allow()
+	assert.ErrorIs(t, err, ErrLimitExceed)
+
+	// 冷却期结束后放开
+	now = now.Add(time.Second)
+	done, err := b.Allow()
+	require.NoError(t, err)
+	done()
+	for _, done := range dones {
+		done()
+	}
+	assert.Equal(t, int64(0), b.inflight.Load())
+}
+
+func TestWindow(t *testing.T) {
+	w := newWindow(3, time.Second)
+	now := time.Unix(1000, 0)
+	w.add(now, 1)
+	w.add(now, 2)
+	w.add(now.Add(time.Second), 5)
+	sums := func(now time.Time) []int64 {
+		var res []int64
+		w.reduce(now, func(b bucket) {
+			res = append(res, b.sum)
+		})
+		return res
+	}
+	// 当前的桶不参与计算
+	assert.Equal(t, []int64{3}, sums(now.Add(time.Second)))
+	assert.Equal(t, []int64{3, 5}, sums(now.Add(2*time.Second)))
+	// 过期的桶不参与计算
+	assert.Equal(t, []int64{5}, sums(now.Add(3*time.Second)))
+	// 复用过期的桶时重新计数
+	w.add(now.Add(3*time.Second), 7)
+	assert.Equal(t, []int64{7}, sums(now.Add(5*time.Second)))
+}
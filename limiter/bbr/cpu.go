@@ -0,0 +1,56 @@
+package bbr
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// cpuInterval CPU 使用率的采样间隔
+	cpuInterval = 500 * time.Millisecond
+	// cpuDecay 指数移动平均的衰减系数，越大越平滑
+	cpuDecay = 0.95
+)
+
+// cpuReader 返回累计的 CPU 使用时间与可用时间，两次采样的增量之比即为这段时间的 CPU 使用率
+type cpuReader func() (used, total uint64, err error)
+
+var (
+	cpuUsage atomic.Int64
+	cpuOnce  sync.Once
+)
+
+// CPUUsage 返回最近的 CPU 使用率，单位为千分之一，第一次调用时开始在后台采样
+//
+// 只支持 Linux，依次尝试 cgroup v2、cgroup v1 与 /proc/stat，都不可用时始终为 0
+func CPUUsage() int64 {
+	cpuOnce.Do(func() {
+		if reader := newCPUReader("/"); reader != nil {
+			go sampleCPU(reader, time.NewTicker(cpuInterval).C)
+		}
+	})
+	return cpuUsage.Load()
+}
+
+// sampleCPU 每次 tick 计算一次使用率，并与之前的结果做指数移动平均
+func sampleCPU(reader cpuReader, tick <-chan time.Time) {
+	prevUsed, prevTotal, err := reader()
+	if err != nil {
+		return
+	}
+	var usage float64
+	for range tick {
+		used, total, err := reader()
+		if err != nil || total <= prevTotal || used < prevUsed {
+			continue
+		}
+		cur := float64(used-prevUsed) / float64(total-prevTotal) * 1000
+		if cur > 1000 {
+			cur = 1000
+		}
+		prevUsed, prevTotal = used, total
+		usage = usage*cpuDecay + cur*(1-cpuDecay)
+		cpuUsage.Store(int64(usage))
+	}
+}
@@ -0,0 +1,148 @@
+//go:build linux
+
+package bbr
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newCPUReader root 为文件系统的根目录，依次尝试 cgroup v2、cgroup v1 与 /proc/stat
+func newCPUReader(root string) cpuReader {
+	readers := []cpuReader{
+		cgroupV2Reader(root),
+		cgroupV1Reader(root),
+		procStatReader(root),
+	}
+	for _, reader := range readers {
+		if _, _, err := reader(); err == nil {
+			return reader
+		}
+	}
+	return nil
+}
+
+// cgroupV2Reader 使用时间来自 cpu.stat 的 usage_usec，可用时间为经过的时间乘以 cpu.max 限制的核数
+func cgroupV2Reader(root string) cpuReader {
+	dir := filepath.Join(root, "sys/fs/cgroup")
+	return func() (uint64, uint64, error) {
+		data, err := os.ReadFile(filepath.Join(dir, "cpu.stat"))
+		if err != nil {
+			return 0, 0, err
+		}
+		var usec uint64
+		found := false
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 2 && fields[0] == "usage_usec" {
+				usec, err = strconv.ParseUint(fields[1], 10, 64)
+				if err != nil {
+					return 0, 0, err
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, 0, errors.New("bbr: cpu.stat 中没有 usage_usec")
+		}
+		cores := cpuCores(-1, 0)
+		if data, err = os.ReadFile(filepath.Join(dir, "cpu.max")); err == nil {
+			// 格式为 "$MAX $PERIOD"，不限制时 $MAX 为 max
+			fields := strings.Fields(string(data))
+			if len(fields) == 2 && fields[0] != "max" {
+				quota, _ := strconv.ParseInt(fields[0], 10, 64)
+				period, _ := strconv.ParseInt(fields[1], 10, 64)
+				cores = cpuCores(quota, period)
+			}
+		}
+		return usec * 1000, uint64(float64(time.Now().UnixNano()) * cores), nil
+	}
+}
+
+// cgroupV1Reader 使用时间来自 cpuacct.usage，可用时间为经过的时间乘以 cpu.cfs_quota_us 限制的核数
+func cgroupV1Reader(root string) cpuReader {
+	dir := filepath.Join(root, "sys/fs/cgroup")
+	return func() (uint64, uint64, error) {
+		usage, err := readUint(filepath.Join(dir, "cpuacct/cpuacct.usage"))
+		if err != nil {
+			return 0, 0, err
+		}
+		cores := cpuCores(-1, 0)
+		quota, qerr := readInt(filepath.Join(dir, "cpu/cpu.cfs_quota_us"))
+		period, perr := readInt(filepath.Join(dir, "cpu/cpu.cfs_period_us"))
+		if qerr == nil && perr == nil {
+			cores = cpuCores(quota, period)
+		}
+		return usage, uint64(float64(time.Now().UnixNano()) * cores), nil
+	}
+}
+
+// procStatReader 整台机器的 CPU 使用率，使用时间为除 idle 与 iowait 之外的所有时间
+func procStatReader(root string) cpuReader {
+	path := filepath.Join(root, "proc/stat")
+	return func() (uint64, uint64, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		if !scanner.Scan() {
+			return 0, 0, errors.New("bbr: /proc/stat 为空")
+		}
+		// cpu  user nice system idle iowait irq softirq steal guest guest_nice
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			return 0, 0, errors.New("bbr: /proc/stat 格式错误")
+		}
+		var total, idle uint64
+		for i, field := range fields[1:] {
+			val, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+			// guest 与 guest_nice 已经计入 user 与 nice
+			if i >= 8 {
+				break
+			}
+			total += val
+			if i == 3 || i == 4 {
+				idle += val
+			}
+		}
+		return total - idle, total, nil
+	}
+}
+
+// cpuCores quota 不大于 0 时表示不限制，使用机器的核数
+func cpuCores(quota, period int64) float64 {
+	if quota <= 0 || period <= 0 {
+		return float64(runtime.NumCPU())
+	}
+	return float64(quota) / float64(period)
+}
+
+func readUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func readInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
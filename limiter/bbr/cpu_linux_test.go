@@ -0,0 +1,75 @@
+//go:build linux
+
+package bbr
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, root, path, content string) {
+	path = filepath.Join(root, path)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestNewCPUReader(t *testing.T) {
+	t.Run("cgroup v2", func(t *testing.T) {
+		root := t.TempDir()
+		writeFile(t, root, "sys/fs/cgroup/cpu.stat", "usage_usec 2000\nuser_usec 1500\n")
+		writeFile(t, root, "sys/fs/cgroup/cpu.max", "200000 100000\n")
+		writeFile(t, root, "proc/stat", "cpu  1 0 1 8 0 0 0 0 0 0\n")
+		used, total, err := newCPUReader(root)()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2000*1000), used)
+		// 限制为 2 核，可用时间为经过时间的两倍
+		assert.InDelta(t, float64(time.Now().UnixNano())*2, float64(total), float64(time.Second))
+	})
+	t.Run("cgroup v1", func(t *testing.T) {
+		root := t.TempDir()
+		writeFile(t, root, "sys/fs/cgroup/cpuacct/cpuacct.usage", "3000\n")
+		writeFile(t, root, "sys/fs/cgroup/cpu/cpu.cfs_quota_us", "50000\n")
+		writeFile(t, root, "sys/fs/cgroup/cpu/cpu.cfs_period_us", "100000\n")
+		used, total, err := newCPUReader(root)()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3000), used)
+		assert.InDelta(t, float64(time.Now().UnixNano())/2, float64(total), float64(time.Second))
+	})
+	t.Run("procfs", func(t *testing.T) {
+		root := t.TempDir()
+		writeFile(t, root, "proc/stat", "cpu  10 2 8 70 10 0 0 0 5 5\ncpu0 10 2 8 70 10 0 0 0 5 5\n")
+		used, total, err := newCPUReader(root)()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(20), used)
+		assert.Equal(t, uint64(100), total)
+	})
+	t.Run("都不可用", func(t *testing.T) {
+		assert.Nil(t, newCPUReader(t.TempDir()))
+	})
+}
+
+func TestSampleCPU(t *testing.T) {
+	var used, total atomic.Uint64
+	reader := func() (uint64, uint64, error) {
+		return used.Load(), total.Load(), nil
+	}
+	tick := make(chan time.Time)
+	go sampleCPU(reader, tick)
+	for i := 0; i < 300; i++ {
+		used.Add(50)
+		total.Add(100)
+		tick <- time.Now()
+	}
+	close(tick)
+	// 使用率为 50%，经过足够多次的移动平均后接近 500
+	require.Eventually(t, func() bool {
+		return cpuUsage.Load() > 490
+	}, time.Second, 10*time.Millisecond)
+	assert.LessOrEqual(t, cpuUsage.Load(), int64(500))
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package bbr
+
+// newCPUReader 非 Linux 系统不采样 CPU，CPUUsage 始终为 0，BBR 不会触发限流
+func newCPUReader(root string) cpuReader {
+	return nil
+}
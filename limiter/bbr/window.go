@@ -0,0 +1,55 @@
+package bbr
+
+import (
+	"sync"
+	"time"
+)
+
+// window 滑动窗口，由 size 个长度为 duration 的桶组成，每个桶记录落在其中的值之和与个数
+type window struct {
+	lock     sync.Mutex
+	buckets  []bucket
+	duration time.Duration
+}
+
+type bucket struct {
+	// idx 桶的序号，即起始时间除以 duration，用于判断桶是否已经过期
+	idx   int64
+	sum   int64
+	count int64
+}
+
+func newWindow(size int, duration time.Duration) *window {
+	return &window{
+		buckets:  make([]bucket, size),
+		duration: duration,
+	}
+}
+
+func (w *window) index(now time.Time) int64 {
+	return now.UnixNano() / int64(w.duration)
+}
+
+func (w *window) add(now time.Time, val int64) {
+	idx := w.index(now)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	b := &w.buckets[idx%int64(len(w.buckets))]
+	if b.idx != idx {
+		*b = bucket{idx: idx}
+	}
+	b.sum += val
+	b.count++
+}
+
+// reduce 遍历窗口内已经结束的桶，当前的桶还在统计中，不参与计算
+func (w *window) reduce(now time.Time, fn func(b bucket)) {
+	cur := w.index(now)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, b := range w.buckets {
+		if b.count > 0 && b.idx < cur && b.idx > cur-int64(len(w.buckets)) {
+			fn(b)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+// Package prometheusx 提供各个包注册 Prometheus 指标时共用的工具
+package prometheusx
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Register 将 c 注册到 reg，reg 为 nil 时使用 prometheus.DefaultRegisterer
+//
+// 同一个指标已经注册时返回已注册的指标，因此重复构建拦截器、中间件时可以共用同一个指标，
+// 名称相同但标签不一致等其他错误会 panic
+func Register[T prometheus.Collector](reg prometheus.Registerer, c T) T {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			panic(err)
+		}
+		return are.ExistingCollector.(T)
+	}
+	return c
+}
@@ -0,0 +1,22 @@
+package prometheusx
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	opts := prometheus.CounterOpts{Name: "requests_total"}
+	first := Register(reg, prometheus.NewCounterVec(opts, []string{"code"}))
+	// 重复注册时返回已注册的指标
+	second := Register(reg, prometheus.NewCounterVec(opts, []string{"code"}))
+	assert.Same(t, first, second)
+
+	// 名称相同但标签不一致
+	assert.Panics(t, func() {
+		Register(reg, prometheus.NewCounterVec(opts, []string{"method"}))
+	})
+}